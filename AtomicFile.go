@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// SaveStep is a step of the replacement of a file by writeFileAtomic
+type SaveStep string
+
+const (
+	SAVE_STEP_STAT      SaveStep = "read the file information"
+	SAVE_STEP_CREATE    SaveStep = "create the temporary file"
+	SAVE_STEP_WRITE     SaveStep = "write the temporary file"
+	SAVE_STEP_SYNC      SaveStep = "sync the temporary file"
+	SAVE_STEP_OWNER     SaveStep = "preserve the owner"
+	SAVE_STEP_MODE      SaveStep = "preserve the permissions"
+	SAVE_STEP_XATTR     SaveStep = "preserve the extended attributes"
+	SAVE_STEP_BACKUP    SaveStep = "back up the previous version"
+	SAVE_STEP_CLOSE     SaveStep = "close the temporary file"
+	SAVE_STEP_RENAME    SaveStep = "replace the file"
+	SAVE_STEP_SYNC_DIR  SaveStep = "sync the directory"
+	SAVE_STEP_OVERWRITE SaveStep = "overwrite the file"
+)
+
+// SaveError is returned when a file cannot be saved, Step tells which step failed.
+// The original file is left untouched unless Step is SAVE_STEP_SYNC_DIR, or SAVE_STEP_OVERWRITE
+// where it can be partially written.
+type SaveError struct {
+	Step SaveStep
+	File string
+	Err  error
+}
+
+// Error returns the error message.
+func (e *SaveError) Error() string {
+	return fmt.Sprintf("cannot save %s, failed to %s: %v", e.File, e.Step, e.Err)
+}
+
+// Unwrap returns the cause of the error.
+func (e *SaveError) Unwrap() error {
+	return e.Err
+}
+
+// writeFileAtomic replaces the content of file by what write writes, without ever leaving a partially written file:
+// the content is written and synced in a temporary file of the same directory, which is then renamed over the file.
+// The mode, owner and extended attributes of an existing file are kept. Symbolic links are followed,
+// but other hard links to the file keep the old content.
+// A file which cannot be replaced, because its directory is not writable or because a new file cannot get its group,
+// is overwritten instead, once the new content is completely written in a temporary file.
+// backup, if not nil, is called with the path of an existing file once the new content is ready,
+// and with overwritten true if the file is overwritten instead of replaced.
+// beforeRename, if not nil, is called just before the rename or the overwrite.
+func writeFileAtomic(file string, write func(w io.Writer) error, backup func(target string, overwritten bool) error, beforeRename func() error) error {
+	target := file
+	info, err := os.Stat(file)
+	if err == nil {
+		if target, err = filepath.EvalSymlinks(file); err != nil {
+			return &SaveError{SAVE_STEP_STAT, file, err}
+		}
+	} else if errors.Is(err, fs.ErrNotExist) {
+		info = nil
+	} else {
+		return &SaveError{SAVE_STEP_STAT, file, err}
+	}
+
+	f, err := createTempFile(target, info == nil)
+	if err != nil {
+		if info != nil && errors.Is(err, fs.ErrPermission) {
+			// the directory is not writable, the file may be
+			fmt.Printf("Cannot create a file next to %s, overwriting it: %v\n", file, err)
+			return overwriteFileFromTemp(file, target, write, backup, beforeRename)
+		}
+		return &SaveError{SAVE_STEP_CREATE, file, err}
+	}
+	tmp := f.Name()
+	done := false
+	defer func() {
+		if !done {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	if err := write(f); err != nil {
+		return &SaveError{SAVE_STEP_WRITE, file, err}
+	}
+	if err := f.Sync(); err != nil {
+		return &SaveError{SAVE_STEP_SYNC, file, err}
+	}
+	if info != nil {
+		// the owner first, changing it can clear the setuid and setgid bits
+		if err := preserveOwner(f, info); err != nil {
+			if !errors.Is(err, fs.ErrPermission) {
+				return &SaveError{SAVE_STEP_OWNER, file, err}
+			}
+			// the new file would not have the group of the file, which keeps it
+			fmt.Printf("Cannot keep the group of %s, overwriting it: %v\n", file, err)
+			return overwriteFile(file, target, f, backup, beforeRename)
+		}
+		if err := f.Chmod(info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)); err != nil {
+			return &SaveError{SAVE_STEP_MODE, file, err}
+		}
+		if err := preserveXattrs(f, target); err != nil {
+			return &SaveError{SAVE_STEP_XATTR, file, err}
+		}
+	}
+	if err := f.Close(); err != nil {
+		return &SaveError{SAVE_STEP_CLOSE, file, err}
+	}
+	if info != nil && backup != nil {
+		if err := backup(target, false); err != nil {
+			return &SaveError{SAVE_STEP_BACKUP, file, err}
+		}
+	}
+	if beforeRename != nil {
+		if err := beforeRename(); err != nil {
+			return &SaveError{SAVE_STEP_RENAME, file, err}
+		}
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return &SaveError{SAVE_STEP_RENAME, file, err}
+	}
+	done = true
+	if err := syncDir(filepath.Dir(target)); err != nil {
+		return &SaveError{SAVE_STEP_SYNC_DIR, file, err}
+	}
+	return nil
+}
+
+// overwriteFileFromTemp writes the new content in a file of the temporary directory, then overwrites target with it
+func overwriteFileFromTemp(file string, target string, write func(w io.Writer) error, backup func(target string, overwritten bool) error, beforeRename func() error) error {
+	f, err := os.CreateTemp("", ".*.tmp")
+	if err != nil {
+		return &SaveError{SAVE_STEP_CREATE, file, err}
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	if err := write(f); err != nil {
+		return &SaveError{SAVE_STEP_WRITE, file, err}
+	}
+	return overwriteFile(file, target, f, backup, beforeRename)
+}
+
+// overwriteFile copies the complete new content of tmp into target, for a file which cannot be replaced by a rename.
+// The file keeps its owner, mode and extended attributes, but a failure while copying leaves it partially written.
+func overwriteFile(file string, target string, tmp *os.File, backup func(target string, overwritten bool) error, beforeRename func() error) error {
+	if backup != nil {
+		if err := backup(target, true); err != nil {
+			return &SaveError{SAVE_STEP_BACKUP, file, err}
+		}
+	}
+	if beforeRename != nil {
+		if err := beforeRename(); err != nil {
+			return &SaveError{SAVE_STEP_RENAME, file, err}
+		}
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return &SaveError{SAVE_STEP_WRITE, file, err}
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return &SaveError{SAVE_STEP_OVERWRITE, file, err}
+	}
+	if _, err := io.Copy(out, tmp); err != nil {
+		out.Close()
+		return &SaveError{SAVE_STEP_OVERWRITE, file, err}
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return &SaveError{SAVE_STEP_OVERWRITE, file, err}
+	}
+	if err := out.Close(); err != nil {
+		return &SaveError{SAVE_STEP_OVERWRITE, file, err}
+	}
+	return nil
+}
+
+// createTempFile creates a new hidden file next to the given one.
+// A file replacing a new file gets the default permissions (0666 minus the umask),
+// otherwise the permissions are restricted until the ones of the replaced file are set.
+func createTempFile(file string, newFile bool) (*os.File, error) {
+	perm := fs.FileMode(0600)
+	if newFile {
+		perm = 0666
+	}
+	for try := 0; ; try++ {
+		f, err := os.OpenFile(getTempFileName(file), os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if err == nil || !errors.Is(err, fs.ErrExist) || try == 100 {
+			return f, err
+		}
+	}
+}
+
+// getTempFileName returns a random hidden file name in the directory of file
+func getTempFileName(file string) string {
+	dir, base := filepath.Split(file)
+	return filepath.Join(dir, fmt.Sprintf(".%s.%08x.tmp", base, rand.Uint32()))
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd)
+
+package main
+
+import (
+	"os"
+)
+
+// preserveXattrs does nothing, extended attributes are not supported on this platform
+func preserveXattrs(f *os.File, file string) error {
+	return nil
+}
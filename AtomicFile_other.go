@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// preserveOwner does nothing, files have no owner to preserve on this platform
+func preserveOwner(f *os.File, info os.FileInfo) error {
+	return nil
+}
+
+// syncDir does nothing, directories cannot be synced on this platform
+func syncDir(dir string) error {
+	return nil
+}
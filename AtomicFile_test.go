@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeString returns a write function of writeFileAtomic writing text
+func writeString(text string) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, text)
+		return err
+	}
+}
+
+// checkFile fails the test if file doesn't contain expected
+func checkFile(t *testing.T, file string, expected string) {
+	t.Helper()
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Fatalf("%s contains %q, expected %q", file, b, expected)
+	}
+}
+
+func TestAtomicFileKeepsMode(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(file, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// not the default mode of a new file
+	if err := os.Chmod(file, 0640); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.Stat(file)
+	if err := writeFileAtomic(file, writeString("new\n"), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, file, "new\n")
+	after, _ := os.Stat(file)
+	if after.Mode() != before.Mode() {
+		t.Fatalf("mode %v, expected %v", after.Mode(), before.Mode())
+	}
+	if os.SameFile(before, after) {
+		t.Fatal("file not replaced")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("temporary file left: %v", entries)
+	}
+}
+
+func TestAtomicFileFollowsLink(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	link := filepath.Join(dir, "link.txt")
+	if err := os.WriteFile(file, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", link); err != nil {
+		t.Skip("symbolic links not supported:", err)
+	}
+	if err := writeFileAtomic(link, writeString("new\n"), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("link replaced", err)
+	}
+	checkFile(t, file, "new\n")
+}
+
+func TestAtomicFileFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(file, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("cannot encode")
+	err := writeFileAtomic(file, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return failure
+	}, nil, nil)
+	var saveErr *SaveError
+	if !errors.As(err, &saveErr) || saveErr.Step != SAVE_STEP_WRITE || !errors.Is(err, failure) {
+		t.Fatal(err)
+	}
+	checkFile(t, file, "old\n")
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("temporary file left: %v", entries)
+	}
+
+	// a new file is not created
+	newFile := filepath.Join(dir, "new.txt")
+	if err := writeFileAtomic(newFile, func(w io.Writer) error { return failure }, nil, nil); !errors.Is(err, failure) {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(newFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("new file created", err)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// preserveOwner gives f the owner and group of the file described by info.
+// Only root can give a file to another user: for the other users, f gets only the group, if the user is a member of it.
+// If the group cannot be kept either, the returned error matches fs.ErrPermission.
+func preserveOwner(f *os.File, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	current, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	c, ok := current.Sys().(*syscall.Stat_t)
+	if ok && c.Uid == stat.Uid && c.Gid == stat.Gid {
+		return nil
+	}
+	err = f.Chown(int(stat.Uid), int(stat.Gid))
+	if !errors.Is(err, syscall.EPERM) {
+		return err
+	}
+	if !ok || c.Gid != stat.Gid {
+		if err := f.Chown(-1, int(stat.Gid)); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Cannot keep the owner %d of %s, only its group %d\n", stat.Uid, info.Name(), stat.Gid)
+	return nil
+}
+
+// syncDir flushes the directory entries, so a rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
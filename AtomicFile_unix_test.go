@@ -0,0 +1,40 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestAtomicFileKeepsOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("only root can give a file to another user")
+	}
+	file := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(file, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const uid, gid = 1234, 5678
+	// the setgid bit is cleared by the change of owner, it must be restored after
+	if err := os.Chown(file, uid, gid); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, 0640|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(file, writeString("new\n"), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, file, "new\n")
+	info, _ := os.Stat(file)
+	stat := info.Sys().(*syscall.Stat_t)
+	if stat.Uid != uid || stat.Gid != gid {
+		t.Fatalf("owner %d:%d, expected %d:%d", stat.Uid, stat.Gid, uid, gid)
+	}
+	if info.Mode() != 0640|os.ModeSetgid {
+		t.Fatalf("mode %v", info.Mode())
+	}
+}
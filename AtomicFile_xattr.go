@@ -0,0 +1,70 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// preserveXattrs copies to f the extended attributes (and so the ACLs on Linux) of file.
+// Attributes not supported by the file system or that the user is not allowed to set are skipped.
+func preserveXattrs(f *os.File, file string) error {
+	names, err := listXattrs(file)
+	if err != nil {
+		if isXattrIgnored(err) {
+			return nil
+		}
+		return err
+	}
+	for _, name := range names {
+		value, err := getXattr(file, name)
+		if err == nil {
+			err = unix.Fsetxattr(int(f.Fd()), name, value, 0)
+		}
+		if err != nil && !isXattrIgnored(err) {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func listXattrs(file string) ([]string, error) {
+	size, err := unix.Listxattr(file, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buffer := make([]byte, size)
+	size, err = unix.Listxattr(file, buffer)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range strings.Split(string(buffer[:size]), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func getXattr(file string, name string) ([]byte, error) {
+	size, err := unix.Getxattr(file, name, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	value := make([]byte, size)
+	size, err = unix.Getxattr(file, name, value)
+	if err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
+
+func isXattrIgnored(err error) bool {
+	return errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BackupMode tells how the previous version of a file is kept when it is saved
+type BackupMode int
+
+const (
+	// BACKUP_NONE keeps no backup
+	BACKUP_NONE BackupMode = iota
+	// BACKUP_TILDE keeps the previous version in file~
+	BACKUP_TILDE
+	// BACKUP_BAK keeps the previous version in file.bak
+	BACKUP_BAK
+	// BACKUP_NUMBERED keeps the previous versions in file.~1~, file.~2~... the highest number being the most recent
+	BACKUP_NUMBERED
+)
+
+// DEFAULT_BACKUP_COUNT is the number of numbered backups kept when SaveOptions.BackupCount is not set
+const DEFAULT_BACKUP_COUNT = 10
+
+// String returns the name of the mode.
+func (m BackupMode) String() string {
+	switch m {
+	case BACKUP_NONE:
+		return "none"
+	case BACKUP_TILDE:
+		return "tilde"
+	case BACKUP_BAK:
+		return "bak"
+	case BACKUP_NUMBERED:
+		return "numbered"
+	}
+	return fmt.Sprintf("BackupMode(%d)", int(m))
+}
+
+// backupFile keeps the current version of file as a backup. The file is hard linked to the backup when possible,
+// copied otherwise, so it must be replaced and not overwritten afterwards, unless overwritten is true: it is then copied.
+// For numbered backups, only the count most recent ones are kept.
+func backupFile(file string, mode BackupMode, count int, overwritten bool) error {
+	var backup string
+	var obsolete []string
+	switch mode {
+	case BACKUP_NONE:
+		return nil
+	case BACKUP_TILDE:
+		backup = file + "~"
+	case BACKUP_BAK:
+		backup = file + ".bak"
+	case BACKUP_NUMBERED:
+		if count <= 0 {
+			count = DEFAULT_BACKUP_COUNT
+		}
+		numbers, err := getBackupNumbers(file)
+		if err != nil {
+			return err
+		}
+		next := 1
+		if len(numbers) > 0 {
+			next = numbers[len(numbers)-1] + 1
+		}
+		backup = getNumberedBackup(file, next)
+		for i := 0; i < len(numbers)+1-count; i++ {
+			obsolete = append(obsolete, getNumberedBackup(file, numbers[i]))
+		}
+	default:
+		return fmt.Errorf("unknown backup mode %d", int(mode))
+	}
+
+	// the backup is created with a temporary name to never leave a partial backup
+	tmp := getTempFileName(file)
+	if overwritten || os.Link(file, tmp) != nil {
+		if err := copyFile(file, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, backup); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	for _, f := range obsolete {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func getNumberedBackup(file string, number int) string {
+	return fmt.Sprintf("%s.~%d~", file, number)
+}
+
+// getBackupNumbers returns the sorted numbers of the existing numbered backups of file
+func getBackupNumbers(file string) ([]int, error) {
+	dir, base := filepath.Split(file)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	prefix := base + ".~"
+	var numbers []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, "~") || len(name) < len(prefix)+2 {
+			continue
+		}
+		n, err := strconv.Atoi(name[len(prefix) : len(name)-1])
+		if err == nil && n > 0 {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// copyFile copies the content and the permissions of source to a new file
+func copyFile(source string, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
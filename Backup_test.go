@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// saveVersion saves text in file with the backup options, failing the test on error
+func saveVersion(t *testing.T, file string, text string, backup BackupMode, count int) {
+	t.Helper()
+	doc := NewDocument()
+	doc.LoadFromString(text, 0)
+	if err := doc.SaveWithOptions(file, UTF8, AUTO, SaveOptions{Backup: backup, BackupCount: count}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listFiles returns the sorted names of the files of dir
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestBackupTildeAndBak(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.conf")
+	// no backup of a new file
+	saveVersion(t, file, "v1", BACKUP_TILDE, 0)
+	if names := listFiles(t, dir); len(names) != 1 {
+		t.Fatal(names)
+	}
+	saveVersion(t, file, "v2", BACKUP_TILDE, 0)
+	saveVersion(t, file, "v3", BACKUP_BAK, 0)
+	saveVersion(t, file, "v4", BACKUP_NONE, 0)
+	checkFile(t, file+"~", "v1")
+	checkFile(t, file+".bak", "v2")
+	checkFile(t, file, "v4")
+	if names := listFiles(t, dir); len(names) != 3 {
+		t.Fatal(names)
+	}
+}
+
+func TestBackupNumberedRetention(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.conf")
+	saveVersion(t, file, "v0", BACKUP_NONE, 0)
+	for i := 1; i <= 6; i++ {
+		saveVersion(t, file, fmt.Sprintf("v%d", i), BACKUP_NUMBERED, 3)
+	}
+	// the 3 most recent backups are kept, the highest number being the most recent
+	expected := []string{"a.conf", "a.conf.~4~", "a.conf.~5~", "a.conf.~6~"}
+	if names := listFiles(t, dir); fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Fatalf("%v, expected %v", names, expected)
+	}
+	checkFile(t, file+".~4~", "v3")
+	checkFile(t, file+".~6~", "v5")
+	checkFile(t, file, "v6")
+
+	// the numbering goes on after the highest existing number, even with a gap
+	if err := os.Remove(file + ".~5~"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file+".~x~", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	saveVersion(t, file, "v7", BACKUP_NUMBERED, 2)
+	expected = []string{"a.conf", "a.conf.~6~", "a.conf.~7~", "a.conf.~x~"}
+	if names := listFiles(t, dir); fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Fatalf("%v, expected %v", names, expected)
+	}
+	checkFile(t, file+".~7~", "v6")
+}
+
+func TestBackupNumberedDefaultCount(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.conf")
+	saveVersion(t, file, "v0", BACKUP_NONE, 0)
+	for i := 1; i <= DEFAULT_BACKUP_COUNT+2; i++ {
+		saveVersion(t, file, fmt.Sprintf("v%d", i), BACKUP_NUMBERED, 0)
+	}
+	if names := listFiles(t, dir); len(names) != DEFAULT_BACKUP_COUNT+1 {
+		t.Fatal(names)
+	}
+	if _, err := os.Stat(getNumberedBackup(file, 2)); !os.IsNotExist(err) {
+		t.Fatal("obsolete backup kept", err)
+	}
+	checkFile(t, getNumberedBackup(file, 3), "v2")
+	checkFile(t, getNumberedBackup(file, DEFAULT_BACKUP_COUNT+2), fmt.Sprintf("v%d", DEFAULT_BACKUP_COUNT+1))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+)
+
+// ByteOrderMark tells if a byte order mark is written when saving
+type ByteOrderMark int
+
+const (
+	// BOM_AUTO writes a byte order mark if the loaded file had one
+	BOM_AUTO ByteOrderMark = iota
+	BOM_ADD
+	BOM_DROP
+)
+
+var (
+	BOM_UTF8    = []byte{0xEF, 0xBB, 0xBF}
+	BOM_UTF16LE = []byte{0xFF, 0xFE}
+	BOM_UTF16BE = []byte{0xFE, 0xFF}
+)
+
+// detectBOM returns the charset given by the byte order mark at the beginning of data
+// and the size of the byte order mark, 0 if there is none.
+func detectBOM(data []byte) (string, int) {
+	switch {
+	case bytes.HasPrefix(data, BOM_UTF8):
+		return UTF8, len(BOM_UTF8)
+	case bytes.HasPrefix(data, BOM_UTF16LE):
+		return "UTF-16LE", len(BOM_UTF16LE)
+	case bytes.HasPrefix(data, BOM_UTF16BE):
+		return "UTF-16BE", len(BOM_UTF16BE)
+	}
+	return "", 0
+}
+
+// stripBOM removes the byte order mark at the beginning of a file content read from the offset skip.
+// It returns the content without byte order mark, the charset (given by the byte order mark if any)
+// and true if a byte order mark was found.
+func stripBOM(data []byte, skip int, charset string) ([]byte, string, bool) {
+	if skip > 0 {
+		return data, charset, false
+	}
+	bomCharset, size := detectBOM(data)
+	if size == 0 {
+		return data, charset, false
+	}
+	return data[size:], bomCharset, true
+}
+
+// getBOM returns the byte order mark of the charset, nil if the charset has none
+func getBOM(charset string) []byte {
+	if IsUTF8(charset) {
+		return BOM_UTF8
+	}
+	switch normalizeCharset(charset) {
+	case "UTF-16LE":
+		return BOM_UTF16LE
+	case "UTF-16", "UTF-16BE":
+		// big endian without byte order mark
+		return BOM_UTF16BE
+	}
+	return nil
+}
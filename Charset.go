@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const UTF8 = "UTF-8"
+
+// encodings by normalized charset name, see normalizeCharset.
+// The encodings don't write byte order marks, they are written once by the save, see getBOM.
+var encodings = map[string]encoding.Encoding{
+	"UTF-16":       unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"UTF-16LE":     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"UTF-16BE":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"ISO-8859-1":   charmap.ISO8859_1,
+	"LATIN1":       charmap.ISO8859_1,
+	"ISO-8859-15":  charmap.ISO8859_15,
+	"LATIN9":       charmap.ISO8859_15,
+	"WINDOWS-1252": charmap.Windows1252,
+	"CP1252":       charmap.Windows1252,
+	"SHIFT-JIS":    japanese.ShiftJIS,
+	"SJIS":         japanese.ShiftJIS,
+}
+
+func normalizeCharset(charset string) string {
+	return strings.ReplaceAll(strings.ToUpper(strings.TrimSpace(charset)), "_", "-")
+}
+
+// IsUTF8 returns true if the charset is UTF-8, the charset used for the text in memory.
+func IsUTF8(charset string) bool {
+	name := normalizeCharset(charset)
+	return name == UTF8 || name == "UTF8"
+}
+
+// GetEncoding returns the encoding of the given charset, or nil for UTF-8 as no conversion is needed.
+func GetEncoding(charset string) (encoding.Encoding, error) {
+	if charset == "" {
+		return nil, fmt.Errorf("null charset")
+	}
+	if IsUTF8(charset) {
+		return nil, nil
+	}
+	if enc, ok := encodings[normalizeCharset(charset)]; ok {
+		return enc, nil
+	}
+	enc, err := ianaindex.IANA.Encoding(charset)
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return enc, nil
+}
+
+// decode converts data from the given charset to UTF-8, data is returned as is for UTF-8
+func decode(data []byte, charset string) ([]byte, error) {
+	enc, err := GetEncoding(charset)
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return data, nil
+	}
+	result, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %w", charset, err)
+	}
+	return result, nil
+}
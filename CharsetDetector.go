@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"unicode/utf8"
+)
+
+// PRELOAD_SIZE is the number of bytes read by PreLoadFrom and used to detect the charset
+const PRELOAD_SIZE = 500000
+
+// CHARSET_AUTO can be given to the load methods to detect the charset from the content
+const CHARSET_AUTO = "auto"
+
+var (
+	xmlEncodingPattern = regexp.MustCompile(`^\s*<\?xml[^>]*\sencoding\s*=\s*["']([A-Za-z0-9._:-]+)["']`)
+	htmlCharsetPattern = regexp.MustCompile(`(?i)<meta[^>]*\scharset\s*=\s*["']?([A-Za-z0-9._:-]+)`)
+)
+
+// CharsetGuess is the result of a charset detection
+type CharsetGuess struct {
+	Charset string
+	// Confidence is between 0 (pure guess) and 1 (certain, e.g. given by a byte order mark)
+	Confidence float64
+}
+
+// String returns a string representation of the guess.
+func (g CharsetGuess) String() string {
+	return fmt.Sprintf("CharsetGuess [charset=%s, confidence=%.2f]", g.Charset, g.Confidence)
+}
+
+// IsAutoCharset returns true if the charset must be detected from the content
+func IsAutoCharset(charset string) bool {
+	return charset == CHARSET_AUTO
+}
+
+// checkCharset returns an error if the charset is not supported, CHARSET_AUTO is accepted
+func checkCharset(charset string) error {
+	if IsAutoCharset(charset) {
+		return nil
+	}
+	_, err := GetEncoding(charset)
+	return err
+}
+
+// resolveCharset returns the charset detected from data if charset is CHARSET_AUTO, charset otherwise
+func resolveCharset(data []byte, charset string) string {
+	if !IsAutoCharset(charset) {
+		return charset
+	}
+	return DetectCharset(data).Charset
+}
+
+// DetectFileCharset detects the charset of a file from its first PRELOAD_SIZE bytes.
+func DetectFileCharset(file string) (CharsetGuess, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return CharsetGuess{}, err
+	}
+	defer f.Close()
+
+	a := make([]byte, PRELOAD_SIZE)
+	n, err := io.ReadFull(f, a)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return CharsetGuess{}, err
+	}
+	return DetectCharset(a[:n]), nil
+}
+
+// DetectCharset guesses the charset of data, the first bytes of a file.
+// The checks are done from the most to the least reliable: byte order mark, UTF-16 null bytes,
+// XML or HTML declaration, UTF-8 validity and finally single byte charsets frequencies.
+func DetectCharset(data []byte) CharsetGuess {
+	if len(data) > PRELOAD_SIZE {
+		data = data[:PRELOAD_SIZE]
+	}
+	if charset, size := detectBOM(data); size > 0 {
+		return CharsetGuess{Charset: charset, Confidence: 1}
+	}
+	if guess, ok := detectUTF16(data); ok {
+		return guess
+	}
+	if guess, ok := detectDeclaredCharset(data); ok {
+		return guess
+	}
+
+	valid, multiByteRunes := checkUTF8(data)
+	if valid {
+		if multiByteRunes == 0 {
+			// pure ASCII, compatible with almost everything
+			return CharsetGuess{Charset: UTF8, Confidence: 0.6}
+		}
+		return CharsetGuess{Charset: UTF8, Confidence: 0.8 + 0.19*minFloat(1, float64(multiByteRunes)/20)}
+	}
+	return detectSingleByte(data)
+}
+
+// detectUTF16 looks for the null bytes of ASCII characters encoded in UTF-16
+func detectUTF16(data []byte) (CharsetGuess, bool) {
+	size := len(data) &^ 1
+	if size < 4 {
+		return CharsetGuess{}, false
+	}
+	evenZeros, oddZeros := 0, 0
+	for i := 0; i < size; i += 2 {
+		if data[i] == 0 {
+			evenZeros++
+		}
+		if data[i+1] == 0 {
+			oddZeros++
+		}
+	}
+	pairs := float64(size / 2)
+	even, odd := float64(evenZeros)/pairs, float64(oddZeros)/pairs
+	switch {
+	case odd > 0.3 && even < 0.05:
+		return CharsetGuess{Charset: "UTF-16LE", Confidence: minFloat(0.95, 0.5+odd/2)}, true
+	case even > 0.3 && odd < 0.05:
+		return CharsetGuess{Charset: "UTF-16BE", Confidence: minFloat(0.95, 0.5+even/2)}, true
+	}
+	return CharsetGuess{}, false
+}
+
+// detectDeclaredCharset looks for an XML encoding declaration or an HTML meta charset
+func detectDeclaredCharset(data []byte) (CharsetGuess, bool) {
+	head := data
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	for _, pattern := range []*regexp.Regexp{xmlEncodingPattern, htmlCharsetPattern} {
+		m := pattern.FindSubmatch(head)
+		if m == nil {
+			continue
+		}
+		charset := string(m[1])
+		if _, err := GetEncoding(charset); err != nil {
+			continue
+		}
+		if IsUTF8(charset) {
+			// a wrong declaration is common, trust it only if the content is valid
+			if valid, _ := checkUTF8(data); !valid {
+				continue
+			}
+		}
+		return CharsetGuess{Charset: charset, Confidence: 0.9}, true
+	}
+	return CharsetGuess{}, false
+}
+
+// checkUTF8 returns true if data is valid UTF-8, ignoring a rune truncated at the end,
+// and the number of multi bytes runes
+func checkUTF8(data []byte) (bool, int) {
+	multiByteRunes := 0
+	for i := 0; i < len(data); {
+		c := data[i]
+		if c < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size <= 1 {
+			if len(data)-i < utf8.UTFMax && !utf8.FullRune(data[i:]) {
+				break
+			}
+			return false, multiByteRunes
+		}
+		multiByteRunes++
+		i += size
+	}
+	return true, multiByteRunes
+}
+
+// detectSingleByte chooses between Shift_JIS, Windows-1252, ISO-8859-15 and ISO-8859-1
+// from the frequencies of the non ASCII bytes
+func detectSingleByte(data []byte) CharsetGuess {
+	if guess, ok := detectShiftJIS(data); ok {
+		return guess
+	}
+
+	highBytes, letters, c1Controls, euro := 0, 0, 0, 0
+	for _, c := range data {
+		if c < 0x80 {
+			continue
+		}
+		highBytes++
+		switch {
+		case c >= 0x80 && c <= 0x9F:
+			c1Controls++
+		case c >= 0xC0 && c != 0xD7 && c != 0xF7:
+			letters++
+		case c == 0xA4:
+			euro++
+		}
+	}
+	if highBytes == 0 {
+		return CharsetGuess{Charset: UTF8, Confidence: 0.6}
+	}
+	// accented letters are the most frequent non ASCII characters in latin texts
+	confidence := 0.3 + 0.5*float64(letters)/float64(highBytes)
+	if c1Controls > 0 {
+		// control characters 0x80-0x9F are almost never used, Windows-1252 has quotes and dashes there
+		return CharsetGuess{Charset: "Windows-1252", Confidence: confidence}
+	}
+	if euro > 0 {
+		return CharsetGuess{Charset: "ISO-8859-15", Confidence: confidence * 0.9}
+	}
+	return CharsetGuess{Charset: "ISO-8859-1", Confidence: confidence}
+}
+
+// detectShiftJIS returns a guess if data is valid Shift_JIS and mostly uses the lead bytes
+// of hiragana, katakana and common kanji, rarely used in latin charsets
+func detectShiftJIS(data []byte) (CharsetGuess, bool) {
+	pairs, frequentLeads, halfWidth := 0, 0, 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c < 0x80:
+			continue
+		case c >= 0xA1 && c <= 0xDF:
+			halfWidth++
+		case (c >= 0x81 && c <= 0x9F) || (c >= 0xE0 && c <= 0xFC):
+			if i+1 == len(data) {
+				// truncated at the end of the window
+				break
+			}
+			t := data[i+1]
+			if t < 0x40 || t == 0x7F || t > 0xFC {
+				return CharsetGuess{}, false
+			}
+			pairs++
+			if c >= 0x81 && c <= 0x9F {
+				frequentLeads++
+			}
+			i++
+		default:
+			return CharsetGuess{}, false
+		}
+	}
+	if pairs == 0 || frequentLeads*2 < pairs || halfWidth > pairs {
+		return CharsetGuess{}, false
+	}
+	return CharsetGuess{Charset: "Shift_JIS", Confidence: 0.5 + 0.4*minFloat(1, float64(pairs)/20)}, true
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// encode returns text encoded with e, failing the test on error
+func encode(t *testing.T, e encoding.Encoding, text string) string {
+	t.Helper()
+	encoded, err := e.NewEncoder().String(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestDetectCharset(t *testing.T) {
+	french := strings.Repeat("Le café est très bon, à côté de l'hôtel.\n", 20)
+	japaneseText := strings.Repeat("日本語のテキストです。ひらがなとカタカナ。\n", 10)
+	latin1 := encode(t, charmap.ISO8859_1, french)
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{"ASCII", "hello world\n", UTF8},
+		{"UTF-8", french, UTF8},
+		{"UTF-8 Japanese", japaneseText, UTF8},
+		// a rune cut by the end of the preloaded bytes
+		{"UTF-8 truncated", french + "\xc3", UTF8},
+		{"UTF-8 byte order mark", "\xef\xbb\xbfabc", UTF8},
+		{"Latin-1", latin1, "ISO-8859-1"},
+		{"Windows-1252", encode(t, charmap.Windows1252, "“quoted” "+french), "Windows-1252"},
+		{"Latin-9", encode(t, charmap.ISO8859_15, "5 € "+french), "ISO-8859-15"},
+		{"Shift_JIS", encode(t, japanese.ShiftJIS, japaneseText), "Shift_JIS"},
+		{"UTF-16LE", encode(t, unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), "hello world\n"), "UTF-16LE"},
+		{"UTF-16BE", encode(t, unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), "hello world\n"), "UTF-16BE"},
+		{"XML declaration", `<?xml version="1.0" encoding="ISO-8859-15"?>` + "\n<a>" + latin1 + "</a>", "ISO-8859-15"},
+		{"XML declaration single quotes", "<?xml version='1.0' encoding='Shift_JIS'?><a/>", "Shift_JIS"},
+		// a wrong UTF-8 declaration is ignored
+		{"XML declaration wrong UTF-8", `<?xml version="1.0" encoding="UTF-8"?>` + "\n<a>" + latin1 + "</a>", "ISO-8859-1"},
+		// an unknown charset is ignored
+		{"XML declaration unknown", `<?xml version="1.0" encoding="FOO-9"?><a>` + french + "</a>", UTF8},
+		{"HTML meta", `<html><head><meta charset="windows-1252"></head>` + french, "windows-1252"},
+	}
+	for _, test := range tests {
+		if guess := DetectCharset([]byte(test.data)); guess.Charset != test.expected {
+			t.Errorf("%s: %v, expected %s", test.name, guess, test.expected)
+		}
+	}
+}
+
+func TestLoadDetectedCharset(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.txt")
+	text := "Le café est très bon.\nà côté de l'hôtel\n"
+	if err := os.WriteFile(file, []byte(encode(t, charmap.ISO8859_1, text)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if guess, err := DetectFileCharset(file); err != nil || guess.Charset != "ISO-8859-1" {
+		t.Fatal(guess, err)
+	}
+	doc := NewDocument()
+	defer doc.Close()
+	if err := doc.LoadFrom(file, 0, CHARSET_AUTO, 0); err != nil {
+		t.Fatal(err)
+	}
+	if doc.GetCharset() != "ISO-8859-1" || doc.GetText(0, doc.GetTotalLength()) != text {
+		t.Fatalf("%s: %q", doc.GetCharset(), doc.GetText(0, doc.GetTotalLength()))
+	}
+	if err := doc.LoadMapped(file, 0, CHARSET_AUTO, 0); err != nil {
+		t.Fatal(err)
+	}
+	if doc.GetCharset() != "ISO-8859-1" || doc.GetText(0, doc.GetTotalLength()) != text {
+		t.Fatalf("mapped %s: %q", doc.GetCharset(), doc.GetText(0, doc.GetTotalLength()))
+	}
+}
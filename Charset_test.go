@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveUTF16ByteOrderMark(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.txt")
+	doc := NewDocument()
+	doc.LoadFromString("a\nb\n", 0)
+	encoded := []byte{0, 'a', 0, '\n', 0, 'b', 0, '\n'}
+	tests := []struct {
+		bom      ByteOrderMark
+		expected []byte
+	}{
+		{BOM_AUTO, encoded},
+		{BOM_ADD, append(append([]byte{}, BOM_UTF16BE...), encoded...)},
+		{BOM_DROP, encoded},
+	}
+	for _, test := range tests {
+		if err := doc.SaveWithOptions(file, "UTF-16", AUTO, SaveOptions{ByteOrderMark: test.bom}); err != nil {
+			t.Fatal(err)
+		}
+		if saved, _ := os.ReadFile(file); !bytes.Equal(saved, test.expected) {
+			t.Fatalf("byte order mark %d: % x", test.bom, saved)
+		}
+	}
+
+	// BOM_AUTO writes the byte order mark of a document having one, only once
+	loaded := NewDocument()
+	if err := loaded.LoadFrom(file, 0, "UTF-16", CHUNK_SIZE); err != nil {
+		t.Fatal(err)
+	}
+	loaded.SetBOM(true)
+	if err := loaded.SaveWithOptions(file, "UTF-16", AUTO, SaveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := os.ReadFile(file); !bytes.Equal(saved, append(append([]byte{}, BOM_UTF16BE...), encoded...)) {
+		t.Fatalf("% x", saved)
+	}
+	if err := loaded.LoadFrom(file, 0, "UTF-16", CHUNK_SIZE); err != nil || !loaded.HasBOM() || loaded.GetText(0, loaded.GetTotalLength()) != "a\nb\n" {
+		t.Fatal(err, loaded.HasBOM(), loaded.GetText(0, loaded.GetTotalLength()))
+	}
+}
+
+func TestLineWriteToWithoutByteOrderMark(t *testing.T) {
+	doc := NewDocument()
+	doc.LoadFromString("a\nb\n", 0)
+	var b bytes.Buffer
+	out := bufio.NewWriter(&b)
+	for i := 0; i < doc.GetLineCount(); i++ {
+		if err := doc.GetLine(i).WriteTo(out, "UTF-16", AUTO); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out.Flush()
+	if !bytes.Equal(b.Bytes(), []byte{0, 'a', 0, '\n', 0, 'b', 0, '\n'}) {
+		t.Fatalf("% x", b.Bytes())
+	}
+}
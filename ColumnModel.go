@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/width"
+)
+
+// DEFAULT_TAB_WIDTH is the number of columns between two tab stops
+const DEFAULT_TAB_WIDTH = 4
+
+// COLUMN_WINDOW is the number of bytes of a line segmented at once into grapheme clusters to compute the columns
+const COLUMN_WINDOW = 4096
+
+// ColumnModel computes the visual columns of the characters of a line displayed with a monospace font.
+// A character is a grapheme cluster: a tab extends to the next tab stop, an East Asian wide or fullwidth character
+// uses 2 columns, a combining mark or a zero width character uses none and the other characters use 1 column.
+// The rendering, the status bar and the rectangular selection share it.
+type ColumnModel struct {
+	tabWidth int
+}
+
+// NewColumnModel creates a column model with the given number of columns between two tab stops.
+func NewColumnModel(tabWidth int) *ColumnModel {
+	m := &ColumnModel{}
+	m.SetTabWidth(tabWidth)
+	return m
+}
+
+// GetTabWidth returns the number of columns between two tab stops.
+func (m *ColumnModel) GetTabWidth() int {
+	return m.tabWidth
+}
+
+// SetTabWidth sets the number of columns between two tab stops.
+func (m *ColumnModel) SetTabWidth(tabWidth int) {
+	if tabWidth < 1 {
+		panic(fmt.Sprintf("invalid tab width %d", tabWidth))
+	}
+	m.tabWidth = tabWidth
+}
+
+// GetColumn returns the visual column of the character at the given byte index of the line, without end of line.
+// An index inside a character gives the column of this character, the length of the line gives the width of the line.
+func (m *ColumnModel) GetColumn(line *Line, index int64) int {
+	if index < 0 || index > line.Length() {
+		panic(fmt.Sprintf("invalid index %d, length is %d", index, line.Length()))
+	}
+	result := -1
+	column := m.forEachCluster(line, func(start, end int64, column, columns int) bool {
+		if end > index {
+			result = column
+			return false
+		}
+		return true
+	})
+	if result < 0 {
+		return column
+	}
+	return result
+}
+
+// GetIndex returns the byte index of the character at the given visual column of the line.
+// A column inside a tab or a wide character gives the index of this character,
+// a column after the end of the line gives the length of the line.
+func (m *ColumnModel) GetIndex(line *Line, column int) int64 {
+	if column < 0 {
+		panic(fmt.Sprintf("invalid column %d", column))
+	}
+	result := line.Length()
+	m.forEachCluster(line, func(start, end int64, c, columns int) bool {
+		if c+columns > column {
+			result = start
+			return false
+		}
+		return true
+	})
+	return result
+}
+
+// GetWidth returns the number of columns of the line, without end of line.
+func (m *ColumnModel) GetWidth(line *Line) int {
+	return m.forEachCluster(line, func(start, end int64, column, columns int) bool {
+		return true
+	})
+}
+
+// ExpandTabs returns the text with its tabs replaced by spaces, for a text starting at the given column.
+func (m *ColumnModel) ExpandTabs(text string, column int) string {
+	if !strings.Contains(text, "\t") {
+		return text
+	}
+	var b strings.Builder
+	for _, r := range text {
+		if r == '\t' {
+			spaces := m.tabWidth - column%m.tabWidth
+			b.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+			continue
+		}
+		b.WriteRune(r)
+		column += runeWidth(r)
+	}
+	return b.String()
+}
+
+// forEachCluster calls f with the byte indexes, the column and the width of each grapheme cluster of the line,
+// until f returns false, and returns the column following the last cluster
+func (m *ColumnModel) forEachCluster(line *Line, f func(start, end int64, column, columns int) bool) int {
+	column := 0
+	start := int64(0)
+	length := line.Length()
+	for start < length {
+		boundaries := line.graphemeBoundaries(start, start+COLUMN_WINDOW)
+		last := len(boundaries) - 1
+		if boundaries[last] < length && last > 1 {
+			// the last cluster of the window can continue after it, it is segmented again with the next window
+			last--
+		}
+		text := line.GetString(start, boundaries[last]-start)
+		for i := 0; i < last; i++ {
+			cluster := text[boundaries[i]-start : boundaries[i+1]-start]
+			w := m.clusterWidth(cluster, column)
+			if !f(boundaries[i], boundaries[i+1], column, w) {
+				return column
+			}
+			column += w
+		}
+		start = boundaries[last]
+	}
+	return column
+}
+
+// clusterWidth returns the number of columns of a grapheme cluster displayed at the given column
+func (m *ColumnModel) clusterWidth(cluster string, column int) int {
+	if cluster == "\t" {
+		return m.tabWidth - column%m.tabWidth
+	}
+	r, _ := utf8.DecodeRuneInString(cluster)
+	if isRegionalIndicator(r) {
+		// a flag
+		return 2
+	}
+	w := runeWidth(r)
+	if w == 1 && strings.ContainsRune(cluster, EMOJI_PRESENTATION_SELECTOR) {
+		// a text character displayed as an emoji
+		return 2
+	}
+	return w
+}
+
+// EMOJI_PRESENTATION_SELECTOR is the variation selector displaying the preceding character as an emoji
+const EMOJI_PRESENTATION_SELECTOR = '\uFE0F'
+
+// runeWidth returns the number of columns of a code point: 0 for the combining marks and the zero width characters,
+// 2 for the East Asian wide and fullwidth characters, 1 for the others
+func runeWidth(r rune) int {
+	switch {
+	case r == utf8.RuneError:
+		return 1
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r >= 0x1160 && r <= 0x11FF:
+		// Hangul vowels and final consonants, combined with the initial consonant
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// String returns a string representation of the column model.
+func (m *ColumnModel) String() string {
+	return fmt.Sprintf("ColumnModel [tabWidth=%d]", m.tabWidth)
+}
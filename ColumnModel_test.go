@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// columnTest is a byte index of a line and its column
+type columnTest struct {
+	line   int
+	index  int64
+	column int
+}
+
+// checkColumns fails the test if a column of tests is not the one computed by m
+func checkColumns(t *testing.T, m *ColumnModel, doc *Document, tests []columnTest) {
+	t.Helper()
+	for _, test := range tests {
+		if got := m.GetColumn(doc.GetLine(test.line), test.index); got != test.column {
+			t.Errorf("line %d, index %d: column %d, expected %d", test.line, test.index, got, test.column)
+		}
+	}
+}
+
+// checkIndexes fails the test if the index of a column of tests is not the one computed by m
+func checkIndexes(t *testing.T, m *ColumnModel, doc *Document, tests []columnTest) {
+	t.Helper()
+	for _, test := range tests {
+		if got := m.GetIndex(doc.GetLine(test.line), test.column); got != test.index {
+			t.Errorf("line %d, column %d: index %d, expected %d", test.line, test.column, got, test.index)
+		}
+	}
+}
+
+func TestColumnModelTabs(t *testing.T) {
+	doc := NewDocument()
+	doc.LoadFromString("a\tb\n\t\tc\nabcd\te", 0)
+	m := NewColumnModel(4)
+	checkColumns(t, m, doc, []columnTest{
+		{0, 0, 0}, {0, 1, 1}, {0, 2, 4}, {0, 3, 5},
+		{1, 1, 4}, {1, 2, 8}, {1, 3, 9},
+		// a tab at a tab stop is a full tab
+		{2, 4, 4}, {2, 5, 8},
+	})
+	// a column inside a tab is at the tab
+	checkIndexes(t, m, doc, []columnTest{
+		{0, 1, 2}, {0, 1, 3}, {0, 2, 4}, {0, 3, 9},
+		{1, 1, 4}, {1, 1, 7}, {1, 2, 8},
+	})
+	if width := m.GetWidth(doc.GetLine(1)); width != 9 {
+		t.Error("width", width)
+	}
+	if text := m.ExpandTabs("ab\tc\t", 1); text != "ab c   " {
+		t.Errorf("expanded %q", text)
+	}
+
+	m.SetTabWidth(8)
+	checkColumns(t, m, doc, []columnTest{{0, 2, 8}, {1, 3, 17}, {2, 5, 8}})
+}
+
+func TestColumnModelWideCharacters(t *testing.T) {
+	doc := NewDocument()
+	// wide, combining, emoji with modifier, emoji with variation selector, flags
+	doc.LoadFromString("日本x\ne\u0301\tz\n👍🏽!\n❤️a\nn\u0301q\n🇫🇷🇩🇪x", 3)
+	m := NewColumnModel(4)
+	checkColumns(t, m, doc, []columnTest{
+		{0, 0, 0}, {0, 3, 2}, {0, 4, 2}, {0, 6, 4}, {0, 7, 5},
+		{1, 1, 0}, {1, 3, 1}, {1, 4, 4}, {1, 5, 5},
+		{2, 4, 0}, {2, 8, 2}, {2, 9, 3},
+		{3, 6, 2}, {3, 7, 3},
+		{4, 2, 0}, {4, 3, 1},
+		{5, 8, 2}, {5, 16, 4}, {5, 17, 5},
+	})
+	// a column inside a wide character is at its start
+	checkIndexes(t, m, doc, []columnTest{
+		{0, 0, 1}, {0, 3, 2}, {0, 6, 4},
+		{2, 0, 1}, {2, 8, 2},
+		{5, 8, 3},
+	})
+	if width := m.GetWidth(doc.GetLine(1)); width != 5 {
+		t.Error("width", width)
+	}
+}
+
+func TestColumnModelLongLine(t *testing.T) {
+	doc := NewDocument()
+	// a line of several parts
+	unit := "日é\t"
+	doc.LoadFromString(strings.Repeat(unit, 3000), 100)
+	line := doc.GetLine(0)
+	m := NewColumnModel(4)
+	if width := m.GetWidth(line); width != 3000*4 {
+		t.Error("width", width)
+	}
+	index := int64(len(unit)*2000 + 3)
+	if column := m.GetColumn(line, index); column != 8002 {
+		t.Error("column", column)
+	}
+	if got := m.GetIndex(line, 8002); got != index {
+		t.Error("index", got)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MAX_DIFF_EDITS is the maximum number of inserted and deleted lines searched by DiffLines
+const MAX_DIFF_EDITS = 2000
+
+// MAX_DIFF_SIZE is the maximum length of the texts compared by the editor
+const MAX_DIFF_SIZE = 16 * 1024 * 1024
+
+// DiffOperation is the kind of a DiffLine
+type DiffOperation int
+
+const (
+	DIFF_EQUAL DiffOperation = iota
+	DIFF_DELETE
+	DIFF_INSERT
+)
+
+// DiffLine is a line of the result of DiffLines
+type DiffLine struct {
+	Operation DiffOperation
+	Text      string
+}
+
+// DiffLines returns the shortest list of deleted and inserted lines changing a into b (Myers' algorithm).
+// The second result is false if a and b have more than MAX_DIFF_EDITS differences, the diff is then not computed.
+func DiffLines(a, b []string) ([]DiffLine, bool) {
+	// the common prefix and suffix are often most of the lines
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	result := make([]DiffLine, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		result = append(result, DiffLine{DIFF_EQUAL, line})
+	}
+	middle, ok := diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	if !ok {
+		return nil, false
+	}
+	result = append(result, middle...)
+	for _, line := range a[len(a)-suffix:] {
+		result = append(result, DiffLine{DIFF_EQUAL, line})
+	}
+	return result, true
+}
+
+func diffMiddle(a, b []string) ([]DiffLine, bool) {
+	n, m := len(a), len(b)
+	limit := minInt(n+m, MAX_DIFF_EDITS)
+	// v[k+offset] is the furthest x reached on the diagonal k = x - y
+	offset := limit + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] keeps the diagonals -d-1 to d+1 of v before the step d
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+				x = v[k+1+offset]
+			} else {
+				x = v[k-1+offset] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+offset] = x
+			if x >= n && y >= m {
+				return diffBacktrack(a, b, trace, d), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// diffBacktrack builds the diff from the end, following the furthest reaching paths of trace
+func diffBacktrack(a, b []string, trace [][]int, d int) []DiffLine {
+	x, y := len(a), len(b)
+	var reversed []DiffLine
+	for ; d > 0; d-- {
+		v := trace[d]
+		offset := d + 1
+		k := x - y
+		var previousK int
+		if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+			previousK = k + 1
+		} else {
+			previousK = k - 1
+		}
+		previousX := v[previousK+offset]
+		previousY := previousX - previousK
+		for x > previousX && y > previousY {
+			x--
+			y--
+			reversed = append(reversed, DiffLine{DIFF_EQUAL, a[x]})
+		}
+		if x > previousX {
+			x--
+			reversed = append(reversed, DiffLine{DIFF_DELETE, a[x]})
+		} else {
+			y--
+			reversed = append(reversed, DiffLine{DIFF_INSERT, b[y]})
+		}
+	}
+	for x > 0 {
+		x--
+		reversed = append(reversed, DiffLine{DIFF_EQUAL, a[x]})
+	}
+	result := make([]DiffLine, len(reversed))
+	for i, line := range reversed {
+		result[len(reversed)-1-i] = line
+	}
+	return result
+}
+
+// FormatUnifiedDiff returns the diff in the unified format, with the given number of context lines around the changes.
+// The lines are expected to end with their end of line.
+func FormatUnifiedDiff(diff []DiffLine, nameA string, nameB string, context int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", nameA, nameB)
+	lineA, lineB := 0, 0
+	for i := 0; i < len(diff); {
+		if diff[i].Operation == DIFF_EQUAL {
+			i++
+			lineA++
+			lineB++
+			continue
+		}
+		// a hunk contains the changes separated by at most 2*context equal lines, and the context around them:
+		// the contexts of two changes separated by 2*context lines are adjacent and merged in the same hunk
+		start := i - minInt(i, context)
+		lastChange := i
+		for end := i; end < len(diff) && end-lastChange <= 2*context+1; end++ {
+			if diff[end].Operation != DIFF_EQUAL {
+				lastChange = end
+			}
+		}
+		end := minInt(lastChange+1+context, len(diff))
+		startA, startB := lineA-(i-start), lineB-(i-start)
+		countA, countB := 0, 0
+		for _, line := range diff[start:end] {
+			if line.Operation != DIFF_INSERT {
+				countA++
+			}
+			if line.Operation != DIFF_DELETE {
+				countB++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", formatHunkRange(startA, countA), formatHunkRange(startB, countB))
+		for _, line := range diff[start:end] {
+			b.WriteString([]string{" ", "-", "+"}[line.Operation])
+			b.WriteString(line.Text)
+			if !strings.HasSuffix(line.Text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		lineA, lineB = startA+countA, startB+countB
+		i = end
+	}
+	return b.String()
+}
+
+// formatHunkRange formats the range of lines of a hunk header, start being the index of its first line.
+// An empty range is given by the line before it, 0 at the start of the file.
+func formatHunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLinesAfter splits text after each end of line (LF, CRLF or CR)
+func splitLinesAfter(text string) []string {
+	var lines []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' || (text[i] == '\r' && (i+1 == len(text) || text[i+1] != '\n')) {
+			lines = append(lines, text[start:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(text) {
+		lines = append(lines, text[start:])
+	}
+	return lines
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
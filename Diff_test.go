@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// unifiedDiff returns the unified diff of a and b with 3 lines of context
+func unifiedDiff(t *testing.T, a, b string) string {
+	t.Helper()
+	diff, ok := DiffLines(splitLinesAfter(a), splitLinesAfter(b))
+	if !ok {
+		t.Fatal("too many differences")
+	}
+	return FormatUnifiedDiff(diff, "a", "b", 3)
+}
+
+func TestUnifiedDiffHunks(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	// changes separated by exactly 2*3 equal lines: one hunk
+	if text := unifiedDiff(t, a, "x\n2\n3\n4\n5\n6\n7\ny\n9\n10\n"); strings.Count(text, "@@ ") != 1 {
+		t.Fatal(text)
+	}
+	// separated by 7 equal lines: two hunks
+	text := unifiedDiff(t, a, "x\n2\n3\n4\n5\n6\n7\n8\ny\n10\n")
+	if strings.Count(text, "@@ ") != 2 || !strings.Contains(text, "@@ -1,4 +1,4 @@") || !strings.Contains(text, "@@ -6,5 +6,5 @@") {
+		t.Fatal(text)
+	}
+}
+
+func TestUnifiedDiffEmptyRanges(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		header string
+	}{
+		// an empty range is given by the line before it
+		{"", "1\n2\n", "@@ -0,0 +1,2 @@"},
+		{"1\n2\n", "", "@@ -1,2 +0,0 @@"},
+		{"1\n2\n3\n4\n5\n", "1\n2\n3\n4\nx\n5\n", "@@ -2,4 +2,5 @@"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n", "1\n2\n3\n4\n5\n6\n7\n8\nx\n", "@@ -6,3 +6,4 @@"},
+	}
+	for _, test := range tests {
+		if text := unifiedDiff(t, test.a, test.b); !strings.Contains(text, test.header+"\n") {
+			t.Errorf("%q to %q: expected %s in\n%s", test.a, test.b, test.header, text)
+		}
+	}
+	if text := unifiedDiff(t, "1\n2\n", "1\n2\n"); text != "--- a\n+++ b\n" {
+		t.Fatal(text)
+	}
+}
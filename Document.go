@@ -3,41 +3,344 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
 )
 
 const CHUNK_SIZE = 1 * 1024 * 1024
 
-// Document struct containing a list of lines and other attributes
+// MAPPED_FILE_SIZE is the file length above which the editor maps a file with LoadMapped instead of reading it
+const MAPPED_FILE_SIZE = 64 * 1024 * 1024
+
+// MAX_PART_OVERFLOW is the number of bytes a part can exceed maxPartSize to end with a complete grapheme cluster,
+// a longer cluster is cut between two code points
+const MAX_PART_OVERFLOW = 256
+
+// LINES_PER_BLOCK is the number of lines created at once, the lines are created on demand
+const LINES_PER_BLOCK = 1024
+
+// MAX_CACHED_BLOCKS is the number of blocks of lines kept in memory
+const MAX_CACHED_BLOCKS = 64
+
+// Document struct containing the text, stored in a piece table, and other attributes.
+// A document is not safe for concurrent use: the goroutines sharing it, like the UI and a Follower,
+// call its methods while holding its lock (see Lock). The other goroutines read a Snapshot.
+// The lines are not stored: they are created by blocks of LINES_PER_BLOCK lines from the piece table and the offsets
+// of the lines, and only the last used blocks are kept.
 type Document struct {
-	lines       []Line
+	table       *PieceTable
+	maxPartSize int
 	totalLength int64
+	// charset of the loaded file, the text in memory is always UTF-8
+	charset string
+	// bom is true if the loaded file started with a byte order mark
+	bom bool
+	// mapping is the mapped file used as original buffer by LoadMapped
+	mapping *MappedFile
+	// blockOffsets are the offsets of the blocks when the offsets of the lines are not created
+	blockOffsets []int64
+	blocks       map[int][]Line
+	blockOrder   []int
+	lineCount    int
+	// offsets of the lines, created when loading or when needed
+	offsets *LineOffsets
+	history *History
+	// journal of the unsaved edits, nil if disabled
+	journal *Journal
+	// fileLength is the number of bytes of the file the document was loaded from, to follow its growth
+	fileLength int64
+	// readOnly is true for a partial document, like a preview
+	readOnly bool
+	// listeners notified of the changes, see AddDocumentListener
+	listeners []DocumentListener
+	// version is incremented by each change of the content
+	version int64
+	// savedVersion is the version when the document was loaded or saved, see IsModified
+	savedVersion int64
+	mutex        sync.Mutex
 }
 
 func NewDocument() *Document {
 	doc := &Document{
-		lines:       make([]Line, 0),
+		table:       NewPieceTable(nil),
+		maxPartSize: CHUNK_SIZE,
 		totalLength: -1,
+		charset:     UTF8,
+		history:     NewHistory(),
+		offsets:     NewLineOffsets([]int64{0}),
 	}
-	emptyParts := []string{""}
-	doc.lines = append(doc.lines, Line{parts: emptyParts})
 	return doc
 }
 
+// GetLines creates all the lines of the document, GetLine and GetLinesRange only create the needed lines
 func (doc *Document) GetLines() []Line {
-	return doc.lines
+	return doc.GetLinesRange(0, doc.GetLineCount())
+}
+
+// GetCharset returns the charset of the loaded file
+func (doc *Document) GetCharset() string {
+	return doc.charset
+}
+
+// HasBOM returns true if the loaded file started with a byte order mark
+func (doc *Document) HasBOM() bool {
+	return doc.bom
+}
+
+// SetBOM sets if a byte order mark is written by Save
+func (doc *Document) SetBOM(bom bool) {
+	doc.bom = bom
 }
 
 func (doc *Document) GetLineCount() int {
-	return len(doc.lines)
+	if doc.offsets != nil {
+		return doc.offsets.GetLineCount()
+	}
+	if doc.blockOffsets == nil {
+		doc.createBlockOffsets()
+	}
+	return doc.lineCount
+}
+
+// GetLine returns the line at the given index, without creating all the lines of the document
+func (doc *Document) GetLine(index int) *Line {
+	block := doc.getLineBlock(index / LINES_PER_BLOCK)
+	return &block[index%LINES_PER_BLOCK]
+}
+
+// GetLinesRange returns count lines starting at index first, without creating all the lines of the document
+func (doc *Document) GetLinesRange(first int, count int) []Line {
+	result := make([]Line, 0, count)
+	for count > 0 {
+		block := doc.getLineBlock(first / LINES_PER_BLOCK)
+		indexInBlock := first % LINES_PER_BLOCK
+		n := min(int64(count), int64(len(block)-indexInBlock))
+		result = append(result, block[indexInBlock:indexInBlock+int(n)]...)
+		first += int(n)
+		count -= int(n)
+	}
+	return result
+}
+
+// createBlockOffsets computes the line count and the offset of the first line of each block.
+// If the mapped file is truncated, the rest of the document is counted as one line.
+func (doc *Document) createBlockOffsets() {
+	offsets := []int64{0}
+	count := 0
+	doc.readMapped(func() {
+		doc.table.scanLineEnds(func(next int64, separator LineSeparator) {
+			count++
+			if count%LINES_PER_BLOCK == 0 {
+				offsets = append(offsets, next)
+			}
+		})
+	})
+	doc.blockOffsets = offsets
+	doc.lineCount = count + 1
+}
+
+// getBlockRange returns the offsets of the first line of the block and of the line following the block,
+// and true if the block is the last one
+func (doc *Document) getBlockRange(blockIndex int) (int64, int64, bool) {
+	if doc.offsets != nil {
+		// the offsets are kept up to date by the edits
+		count := doc.offsets.GetLineCount()
+		next := (blockIndex + 1) * LINES_PER_BLOCK
+		if next >= count {
+			return doc.offsets.GetOffset(blockIndex * LINES_PER_BLOCK), doc.offsets.GetTotalLength(), true
+		}
+		return doc.offsets.GetOffset(blockIndex * LINES_PER_BLOCK), doc.offsets.GetOffset(next), false
+	}
+	if doc.blockOffsets == nil {
+		doc.createBlockOffsets()
+	}
+	if blockIndex == len(doc.blockOffsets)-1 {
+		return doc.blockOffsets[blockIndex], doc.table.Length(), true
+	}
+	return doc.blockOffsets[blockIndex], doc.blockOffsets[blockIndex+1], false
+}
+
+// getLineBlock returns the lines of the given block, creating them if not in the cache.
+// If the mapped file is truncated, the lines which cannot be read are empty, until the document is reloaded.
+func (doc *Document) getLineBlock(blockIndex int) []Line {
+	block, err := doc.readLineBlock(blockIndex)
+	if err != nil {
+		count := LINES_PER_BLOCK
+		if _, _, last := doc.getBlockRange(blockIndex); last {
+			count = doc.GetLineCount() - blockIndex*LINES_PER_BLOCK
+		}
+		splitter := newLineSplitter(doc.maxPartSize, blockIndex*LINES_PER_BLOCK)
+		splitter.write(bytes.Repeat([]byte{'\n'}, count))
+		return splitter.finish()[:count]
+	}
+	return block
+}
+
+// readLineBlock returns the lines of the given block, creating them if not in the cache,
+// or an error if the mapped file is truncated
+func (doc *Document) readLineBlock(blockIndex int) ([]Line, error) {
+	if block, ok := doc.blocks[blockIndex]; ok {
+		return block, nil
+	}
+
+	start, end, last := doc.getBlockRange(blockIndex)
+	var data []byte
+	if err := doc.readMapped(func() { data = doc.table.Bytes(start, end) }); err != nil {
+		return nil, err
+	}
+	splitter := newLineSplitter(doc.maxPartSize, blockIndex*LINES_PER_BLOCK)
+	splitter.write(data)
+	block := splitter.finish()
+	if !last {
+		// the block ends with a new line, drop the empty line created after it
+		block = block[:LINES_PER_BLOCK]
+	}
+
+	if doc.blocks == nil {
+		doc.blocks = make(map[int][]Line)
+	}
+	if len(doc.blockOrder) >= MAX_CACHED_BLOCKS {
+		delete(doc.blocks, doc.blockOrder[0])
+		doc.blockOrder = doc.blockOrder[1:]
+	}
+	doc.blocks[blockIndex] = block
+	doc.blockOrder = append(doc.blockOrder, blockIndex)
+	return block, nil
+}
+
+// invalidLineCaches drops what is computed from the lines: the blocks and the offsets
+func (doc *Document) invalidLineCaches() {
+	doc.blockOffsets = nil
+	doc.blocks = nil
+	doc.blockOrder = nil
+	doc.offsets = nil
+}
+
+// invalidLineBlocks drops the blocks of the lines first to last (included) after an edit,
+// and the blocks of the following lines if the edit changed their indexes
+func (doc *Document) invalidLineBlocks(first int, last int, moved bool) {
+	doc.blockOffsets = nil
+	firstBlock := first / LINES_PER_BLOCK
+	lastBlock := last / LINES_PER_BLOCK
+	kept := doc.blockOrder[:0]
+	for _, blockIndex := range doc.blockOrder {
+		if blockIndex < firstBlock || (blockIndex > lastBlock && !moved) {
+			kept = append(kept, blockIndex)
+		} else {
+			delete(doc.blocks, blockIndex)
+		}
+	}
+	doc.blockOrder = kept
+}
+
+// getLineOffsets returns the offsets of the lines, creating them if needed
+func (doc *Document) getLineOffsets() *LineOffsets {
+	if doc.offsets == nil {
+		// no need to create the lines, the ends of line are enough
+		var lengths []int64
+		previous := int64(0)
+		// if the mapped file is truncated, the rest of the document is one line
+		doc.readMapped(func() {
+			doc.table.scanLineEnds(func(next int64, separator LineSeparator) {
+				lengths = append(lengths, next-previous)
+				previous = next
+			})
+		})
+		lengths = append(lengths, doc.table.Length()-previous)
+		doc.offsets = NewLineOffsets(lengths)
+	}
+	return doc.offsets
 }
 
+// GetIndex returns the line and the index in this line of the character at the given global index
+func (doc *Document) GetIndex(globalIndex int64) *Index {
+	offsets := doc.getLineOffsets()
+	lineIndex := offsets.FindLine(globalIndex)
+	return NewIndex(lineIndex, globalIndex-offsets.GetOffset(lineIndex))
+}
+
+// GetGlobalIndex returns the global index of the character at the given index in a line
+func (doc *Document) GetGlobalIndex(lineIndex int, charIndexInLine int64) int64 {
+	return doc.getLineOffsets().GetOffset(lineIndex) + charIndexInLine
+}
+
+// GetGlobalIndexOfLine returns the global index of the first character of the line
+func (doc *Document) GetGlobalIndexOfLine(lineIndex int) int64 {
+	return doc.getLineOffsets().GetOffset(lineIndex)
+}
+
+// GetNextCharIndex returns the global index following the character at globalIndex, a grapheme cluster
+// or a whole end of line, to move the cursor forward. The total length is returned at the end of the document.
+func (doc *Document) GetNextCharIndex(globalIndex int64) int64 {
+	index := doc.GetIndex(globalIndex)
+	line := doc.GetLine(index.GetLineIndex())
+	lineStart := globalIndex - index.GetCharIndexInLine()
+	if index.GetCharIndexInLine() >= line.Length() {
+		return lineStart + line.GetLengthWithEOL()
+	}
+	return lineStart + line.NextGraphemeBoundary(index.GetCharIndexInLine())
+}
+
+// GetPreviousCharIndex returns the global index of the character preceding globalIndex, a grapheme cluster
+// or a whole end of line, to move the cursor backward.
+func (doc *Document) GetPreviousCharIndex(globalIndex int64) int64 {
+	index := doc.GetIndex(globalIndex)
+	lineStart := globalIndex - index.GetCharIndexInLine()
+	if index.GetCharIndexInLine() == 0 {
+		if index.GetLineIndex() == 0 {
+			return 0
+		}
+		// before the end of the previous line
+		previous := doc.GetLine(index.GetLineIndex() - 1)
+		return lineStart - previous.GetLengthWithEOL() + previous.Length()
+	}
+	line := doc.GetLine(index.GetLineIndex())
+	if index.GetCharIndexInLine() > line.Length() {
+		// inside a CRLF
+		return lineStart + line.Length()
+	}
+	return lineStart + line.PreviousGraphemeBoundary(index.GetCharIndexInLine())
+}
+
+// CheckPartBoundaries returns an error if a line has a code point cut between two of its parts.
+func (doc *Document) CheckPartBoundaries() error {
+	return doc.forEachLine(func(line *Line) error {
+		return line.checkPartBoundaries()
+	})
+}
+
+// forEachLine calls f for each line, creating the lines block by block
+func (doc *Document) forEachLine(f func(line *Line) error) error {
+	count := doc.GetLineCount()
+	for first := 0; first < count; first += LINES_PER_BLOCK {
+		block, err := doc.readLineBlock(first / LINES_PER_BLOCK)
+		if err != nil {
+			return err
+		}
+		for i := range block {
+			if err := f(&block[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PreLoadFrom loads only the first PRELOAD_SIZE bytes of the file, to show a preview while the file is loaded.
+// The document is read only, it cannot be saved.
 func (doc *Document) PreLoadFrom(file string, skip int, charset string, max int) error {
+	if err := checkCharset(charset); err != nil {
+		return err
+	}
 	f, err := os.Open(file)
 	if err != nil {
 		return err
@@ -51,89 +354,249 @@ func (doc *Document) PreLoadFrom(file string, skip int, charset string, max int)
 		}
 	}
 
-	a := make([]byte, 500000)
-	_, err = f.Read(a)
+	a := make([]byte, PRELOAD_SIZE)
+	n, err := f.Read(a)
 	if err != nil && err != io.EOF {
 		return err
 	}
 
-	start := string(a)
-	doc.LoadFromString(start, max)
+	data, charset, bom := stripBOM(a[:n], skip, resolveCharset(a[:n], charset))
+	text, err := decode(data, charset)
+	if err != nil {
+		return err
+	}
+	doc.loadFromBytes(text, max)
+	doc.charset = charset
+	doc.bom = bom
+	doc.fileLength = int64(skip + n)
+	doc.readOnly = true
+	doc.fireReloaded()
 	return nil
 }
 
 func (doc *Document) LoadFrom(file string, skip int, charset string, max int) error {
 	fmt.Printf("Document.LoadFrom() %s  from %d %s\n", file, skip, charset)
+	if err := checkCharset(charset); err != nil {
+		return err
+	}
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
 		return err
 	}
+	fileLength := int64(len(data))
 
-	if skip == 0 {
-		all := string(data)
-		doc.LoadFromString(all, max)
-	} else {
-		b := bytes.NewBuffer(data[skip:])
-		all := b.String()
-		doc.LoadFromString(all, max)
+	// for UTF-8 the piece table uses the file content as original buffer, no copy needed
+	data, charset, bom := stripBOM(data[skip:], skip, resolveCharset(data[skip:], charset))
+	text, err := decode(data, charset)
+	if err != nil {
+		return err
 	}
+	doc.loadFromBytes(text, max)
+	doc.charset = charset
+	doc.bom = bom
+	doc.fileLength = fileLength
+	doc.fireReloaded()
+	return nil
+}
 
+// LoadMapped maps the file in memory instead of reading it.
+// The lines are only created for the parts of the document accessed with GetLine or GetLinesRange,
+// the rest of the file stays on disk. Close must be called when the document is not used anymore.
+// Only UTF-8 files can stay on disk, files in other charsets are decoded in memory.
+func (doc *Document) LoadMapped(file string, skip int, charset string, max int) error {
+	if !IsUTF8(charset) && !IsAutoCharset(charset) {
+		return doc.LoadFrom(file, skip, charset, max)
+	}
+	mapping, err := OpenMappedFile(file)
+	if err != nil {
+		return err
+	}
+	if int64(skip) > mapping.Length() {
+		mapping.Close()
+		return fmt.Errorf("skip %d is greater than the file length (%d)", skip, mapping.Length())
+	}
+	data := mapping.Bytes()[skip:]
+	bom := false
+	err = mapping.read(func() {
+		data, charset, bom = stripBOM(data, skip, resolveCharset(data[:minInt64(int64(len(data)), PRELOAD_SIZE)], charset))
+	})
+	if err != nil {
+		mapping.Close()
+		return err
+	}
+	if !IsUTF8(charset) {
+		// the byte order mark or the detected charset is not UTF-8
+		mapping.Close()
+		return doc.LoadFrom(file, skip, charset, max)
+	}
+
+	doc.close()
+	doc.mapping = mapping
+	doc.setTable(NewPieceTable(data), nil)
+	doc.maxPartSize = max
+	doc.charset = charset
+	doc.bom = bom
+	doc.fileLength = mapping.Length()
+	doc.fireReloaded()
 	return nil
 }
 
+// Close releases the mapped file used by LoadMapped, the document is empty after
+func (doc *Document) Close() error {
+	if doc.mapping == nil {
+		return nil
+	}
+	err := doc.close()
+	doc.fireReloaded()
+	return err
+}
+
+// close releases the mapped file without notifying the listeners, the document is reloaded after
+func (doc *Document) close() error {
+	if doc.mapping == nil {
+		return nil
+	}
+	// the history can reference the mapped content, setTable clears it
+	doc.setTable(NewPieceTable(nil), nil)
+	err := doc.mapping.Close()
+	doc.mapping = nil
+	return err
+}
+
+// readMapped calls read, which reads the text of the document, and returns ErrMappedFileTruncated
+// if the mapped file was truncated by another program, see MappedFile.read
+func (doc *Document) readMapped(read func()) error {
+	if doc.mapping == nil {
+		read()
+		return nil
+	}
+	return doc.mapping.read(read)
+}
+
+// setTable replaces the content of the document, lengths are the lengths of the lines, nil to compute them when needed
+func (doc *Document) setTable(table *PieceTable, lengths []int64) {
+	doc.table = table
+	doc.invalidLineCaches()
+	if lengths != nil {
+		doc.offsets = NewLineOffsets(lengths)
+	}
+	doc.invalidLength()
+	doc.history.Clear()
+	doc.readOnly = false
+	if doc.journal != nil {
+		// the edits recorded apply to the replaced content
+		doc.journal.Remove()
+		doc.journal = nil
+	}
+}
+
 func (doc *Document) LoadFromString(str string, maxPartSize int) {
-	doc.lines = nil // clear the lines
+	doc.loadFromBytes([]byte(str), maxPartSize)
+	doc.charset = UTF8
+	doc.bom = false
+	doc.fireReloaded()
+}
+
+func (doc *Document) loadFromBytes(data []byte, maxPartSize int) {
 	startTime := time.Now()
+	doc.close()
+	doc.setTable(NewPieceTable(data), splitLineLengths(data))
+	doc.maxPartSize = maxPartSize
+	doc.fileLength = 0
+	fmt.Printf("Document.LoadFromString() took %dms\n", time.Since(startTime).Milliseconds())
+}
 
-	size := len(str)
-	var parts []string
-	var b strings.Builder
-	counter := 0
-	returnFound := false
+// lineSplitter creates lines from bytes given in one or several blocks
+type lineSplitter struct {
+	maxPartSize    int
+	firstLineIndex int
+	lines          []Line
+	parts          []string
+	b              strings.Builder
+	// returnFound is true if the last byte was a '\r', the line ends with CR or CRLF depending on the next byte
+	returnFound bool
+}
 
-	for i := 0; i < size; i++ {
-		c := str[i]
+func newLineSplitter(maxPartSize int, firstLineIndex int) *lineSplitter {
+	return &lineSplitter{
+		maxPartSize:    maxPartSize,
+		firstLineIndex: firstLineIndex,
+	}
+}
 
-		if c == '\n' {
-			if b.Len() > 0 {
-				parts = append(parts, b.String())
+func (s *lineSplitter) write(data []byte) {
+	for i, c := range data {
+		if s.returnFound {
+			s.returnFound = false
+			if c == '\n' {
+				s.endLine(CRLF)
+				continue
 			}
-			line := Line{parts: parts, lineIndex: len(doc.lines)}
-			line.endsWithNewLine = true
-			line.carriageReturn = returnFound
-			doc.lines = append(doc.lines, line)
-			b.Reset()
-			counter = 0
-			parts = nil
-			returnFound = false
+			s.endLine(CR)
+		}
+
+		if c == '\n' {
+			s.endLine(LF)
 		} else if c == '\r' {
-			returnFound = true
+			s.returnFound = true
 		} else {
-			if counter > maxPartSize {
-				parts = append(parts, b.String())
-				b.Reset()
-				counter = 0
+			if s.b.Len() > 0 && s.b.Len() >= s.maxPartSize && s.canCut(data[i:]) {
+				s.parts = append(s.parts, s.b.String())
+				s.b.Reset()
 			}
-			b.WriteByte(c)
+			s.b.WriteByte(c)
 		}
+	}
+}
 
-		counter++
+// canCut returns true if a new part can start with next, the following bytes of the line.
+// Parts are cut between two grapheme clusters, or between two code points for a cluster longer than MAX_PART_OVERFLOW.
+func (s *lineSplitter) canCut(next []byte) bool {
+	overflow := s.b.Len() - s.maxPartSize
+	if !utf8.RuneStart(next[0]) {
+		// inside a code point, or an invalid byte
+		return overflow >= MAX_PART_OVERFLOW+utf8.UTFMax
+	}
+	if overflow >= MAX_PART_OVERFLOW {
+		return true
 	}
+	// a code point cut at the end of next is not an extension
+	r, _ := utf8.DecodeRune(next)
+	return !isGraphemeExtension(s.b.String(), r)
+}
 
-	if b.Len() > 0 {
-		parts = append(parts, b.String())
+func (s *lineSplitter) endLine(separator LineSeparator) {
+	if s.b.Len() > 0 {
+		s.parts = append(s.parts, s.b.String())
 	}
-	line := Line{parts: parts, lineIndex: len(doc.lines)}
-	doc.lines = append(doc.lines, line)
+	line := Line{parts: s.parts, lineIndex: s.firstLineIndex + len(s.lines)}
+	line.computeLength()
+	line.SetLineSeparator(separator)
+	s.lines = append(s.lines, line)
+	s.b.Reset()
+	s.parts = nil
+}
 
-	fmt.Printf("Document.LoadFromString() took %dms\n", time.Since(startTime).Milliseconds())
-	doc.invalidLength()
+// finish creates the last line, not ended by a new line, and returns all the lines
+func (s *lineSplitter) finish() []Line {
+	if s.returnFound {
+		s.returnFound = false
+		s.endLine(CR)
+	}
+	if s.b.Len() > 0 {
+		s.parts = append(s.parts, s.b.String())
+	}
+	line := Line{parts: s.parts, lineIndex: s.firstLineIndex + len(s.lines)}
+	line.computeLength()
+	s.lines = append(s.lines, line)
+	return s.lines
 }
 
 func (doc *Document) Dump(out io.Writer) {
-	for _, line := range doc.lines {
+	doc.forEachLine(func(line *Line) error {
 		line.dump(out)
-	}
+		return nil
+	})
 }
 
 func (doc *Document) invalidLength() {
@@ -141,59 +604,435 @@ func (doc *Document) invalidLength() {
 }
 
 func (doc *Document) Save(file string, charset string, lineSeparator LineSeparator) error {
-	if charset == "" {
-		return fmt.Errorf("null charset")
+	return doc.SaveWithOptions(file, charset, lineSeparator, SaveOptions{})
+}
+
+// SaveWithOptions saves the document, see SaveOptions for the available options.
+// By default a byte order mark is written if the loaded file had one and the charset supports it.
+// The file is replaced atomically: a failure or a crash during the save leaves the previous content intact.
+// The previous content can also be kept as a backup, see SaveOptions.Backup.
+func (doc *Document) SaveWithOptions(file string, charset string, lineSeparator LineSeparator, options SaveOptions) error {
+	if doc.readOnly {
+		return errors.New("the document is read only")
+	}
+	if _, err := GetEncoding(charset); err != nil {
+		return err
+	}
+	write := func(w io.Writer) error {
+		return doc.writeTo(w, charset, lineSeparator, options)
+	}
+	backup := func(target string, overwritten bool) error {
+		return backupFile(target, options.Backup, options.BackupCount, overwritten)
+	}
+	if !doc.isMapped(file) {
+		err := writeFileAtomic(file, write, backup, nil)
+		if err == nil {
+			doc.resetJournal(file, charset)
+			doc.fireSaved(file)
+		}
+		return err
 	}
 
-	f, err := os.Create(file)
+	// the mapped file is read while writing and can only be unmapped just before being replaced
+	maxPartSize := doc.maxPartSize
+	loadedCharset := doc.charset
+	// the journal is kept across the reload
+	journal := doc.journal
+	doc.journal = nil
+	err := writeFileAtomic(file, write, backup, doc.close)
+	if err != nil && doc.mapping != nil {
+		// failed before unmapping, the document is unchanged
+		doc.journal = journal
+		return err
+	}
+	var saveErr *SaveError
+	if errors.As(err, &saveErr) && saveErr.Step == SAVE_STEP_RENAME {
+		// the previous file is still there
+		charset = loadedCharset
+	}
+	loadErr := doc.LoadMapped(file, 0, charset, maxPartSize)
 	if err != nil {
+		// the edits are lost by the reload, but can still be recovered from the journal
+		if journal != nil {
+			journal.Close()
+		}
 		return err
 	}
-	defer f.Close()
+	doc.journal = journal
+	doc.resetJournal(file, charset)
+	doc.fireSaved(file)
+	return loadErr
+}
 
-	writer := bufio.NewWriter(f)
-	for _, line := range doc.lines {
-		err := line.writeTo(writer, charset, lineSeparator)
-		if err != nil {
+// writeTo writes the document encoded in the given charset
+func (doc *Document) writeTo(w io.Writer, charset string, lineSeparator LineSeparator, options SaveOptions) error {
+	bom := getBOM(charset)
+	if bom != nil && (options.ByteOrderMark == BOM_ADD || (options.ByteOrderMark == BOM_AUTO && doc.bom)) {
+		if _, err := w.Write(bom); err != nil {
 			return err
 		}
 	}
 
-	return writer.Flush()
+	enc, err := GetEncoding(charset)
+	if err != nil {
+		return err
+	}
+	out := w
+	var encoder *transform.Writer
+	if enc != nil {
+		encoder = transform.NewWriter(w, enc.NewEncoder())
+		out = encoder
+	}
+
+	writer := bufio.NewWriter(out)
+	err = doc.forEachLine(func(line *Line) error {
+		return line.writeTo(writer, lineSeparator)
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil && encoder != nil {
+		err = encoder.Close()
+	}
+	if err != nil && encoder != nil {
+		return fmt.Errorf("cannot save in %s: %w", charset, err)
+	}
+	return err
 }
 
-// Additional Methods like `createTextLines`, `getGlobalIndex`, `delete`, `insert`, etc. would need to be implemented in a similar fashion, but for brevity, only a subset of the Java methods have been translated.
+// isMapped returns true if the given file is the one mapped by LoadMapped
+func (doc *Document) isMapped(file string) bool {
+	if doc.mapping == nil {
+		return false
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(info, doc.mapping.Stat())
+}
 
-func (line *Line) dump(out io.Writer) {
-	for _, part := range line.parts {
-		fmt.Fprintln(out, part)
+// GetTotalLength returns the length of the document, ends of line included
+func (doc *Document) GetTotalLength() int64 {
+	if doc.totalLength < 0 {
+		doc.totalLength = doc.table.Length()
 	}
+	return doc.totalLength
 }
 
-func (line *Line) writeTo(out *bufio.Writer, charset string, separator LineSeparator) error {
-	for _, part := range line.parts {
-		if _, err := out.WriteString(part); err != nil {
-			return err
+// Lock locks the document for the calling goroutine, until Unlock.
+// The listeners are notified in the goroutine holding the lock, they must not lock the document.
+func (doc *Document) Lock() {
+	doc.mutex.Lock()
+}
+
+// Unlock unlocks the document locked by Lock.
+func (doc *Document) Unlock() {
+	doc.mutex.Unlock()
+}
+
+// GetVersion returns the version of the content, incremented by each edit and load.
+func (doc *Document) GetVersion() int64 {
+	return doc.version
+}
+
+// IsModified returns true if the document was edited since it was loaded or saved.
+// The text appended by a Follower comes from the file, it doesn't modify the document.
+func (doc *Document) IsModified() bool {
+	return doc.version != doc.savedVersion
+}
+
+// SetReadOnly forbids or allows the edits of the document.
+func (doc *Document) SetReadOnly(readOnly bool) {
+	doc.readOnly = readOnly
+}
+
+// IsReadOnly returns true if the document cannot be edited.
+func (doc *Document) IsReadOnly() bool {
+	return doc.readOnly
+}
+
+// checkWritable panics if the document is read only
+func (doc *Document) checkWritable() {
+	if doc.readOnly {
+		panic("the document is read only")
+	}
+}
+
+// GetText returns the text between start (inclusive) and end (exclusive) global indexes,
+// or an empty string if the mapped file was truncated
+func (doc *Document) GetText(start, end int64) string {
+	var text []byte
+	if err := doc.readMapped(func() { text = doc.table.Bytes(start, end) }); err != nil {
+		fmt.Printf("Cannot read the text: %v\n", err)
+		return ""
+	}
+	return string(text)
+}
+
+// Insert inserts text, which can contain new lines, at the given global index
+func (doc *Document) Insert(globalIndex int64, text string) {
+	doc.checkWritable()
+	if len(text) == 0 {
+		return
+	}
+	first := doc.getLineOffsets().FindLine(globalIndex)
+	doc.table.Insert(globalIndex, text)
+	change := doc.updateLines(first, first, int64(len(text)))
+	if doc.journal != nil {
+		doc.checkJournal(doc.journal.insert(globalIndex, []byte(text)))
+	}
+	end := globalIndex + int64(len(text))
+	doc.history.add(false, globalIndex, doc.table.Pieces(globalIndex, end), strings.ContainsAny(text, "\r\n"))
+	doc.compactHistory()
+	doc.fireEdit(EVENT_INSERTED, globalIndex, end, change)
+}
+
+// Delete removes the text between start (inclusive) and end (exclusive) global indexes, lines are merged if needed
+func (doc *Document) Delete(start, end int64) {
+	doc.checkWritable()
+	if start == end {
+		return
+	}
+	pieces := doc.table.Pieces(start, end)
+	change := doc.deleteRange(start, end)
+	doc.history.add(true, start, pieces, false)
+	doc.compactHistory()
+	doc.fireEdit(EVENT_REMOVED, start, end, change)
+}
+
+// compactHistory frees the inserted text only referenced by the steps dropped from the history
+func (doc *Document) compactHistory() {
+	if doc.history.needsCompaction(len(doc.table.add)) {
+		doc.history.setPieces(doc.table.compactAdd(doc.history.getPieces()))
+	}
+}
+
+// appendText adds text at the end of the document, without recording it in the history
+func (doc *Document) appendText(text string) {
+	end := doc.GetTotalLength()
+	first := doc.getLineOffsets().FindLine(end)
+	modified := doc.IsModified()
+	doc.table.Insert(end, text)
+	change := doc.updateLines(first, first, int64(len(text)))
+	doc.fireEdit(EVENT_INSERTED, end, end+int64(len(text)), change)
+	if !modified {
+		doc.savedVersion = doc.version
+	}
+}
+
+// insertPieces inserts pieces of the piece table, without recording it in the history
+func (doc *Document) insertPieces(globalIndex int64, pieces []piece) lineChange {
+	first := doc.getLineOffsets().FindLine(globalIndex)
+	doc.table.InsertPieces(globalIndex, pieces)
+	length := piecesLength(pieces)
+	change := doc.updateLines(first, first, length)
+	if doc.journal != nil {
+		doc.checkJournal(doc.journal.insert(globalIndex, doc.table.Bytes(globalIndex, globalIndex+length)))
+	}
+	return change
+}
+
+// deleteRange removes text, without recording it in the history
+func (doc *Document) deleteRange(start, end int64) lineChange {
+	offsets := doc.getLineOffsets()
+	first := offsets.FindLine(start)
+	last := offsets.FindLine(end)
+	doc.table.Delete(start, end)
+	change := doc.updateLines(first, last, start-end)
+	if doc.journal != nil {
+		doc.checkJournal(doc.journal.delete(start, end))
+	}
+	return change
+}
+
+// EnableJournal records the next edits in a journal, to recover them if the editor crashes before the document is saved.
+// The document must have been loaded from the given file, not modified since.
+func (doc *Document) EnableJournal(file string) error {
+	journal, err := newJournal(file, doc.charset)
+	if err != nil {
+		return err
+	}
+	doc.DisableJournal()
+	journal.hashInBackground()
+	doc.journal = journal
+	return nil
+}
+
+// DisableJournal stops recording the edits and removes the journal.
+func (doc *Document) DisableJournal() error {
+	if doc.journal == nil {
+		return nil
+	}
+	err := doc.journal.Remove()
+	doc.journal = nil
+	return err
+}
+
+// GetJournal returns the journal of the edits, nil if disabled
+func (doc *Document) GetJournal() *Journal {
+	return doc.journal
+}
+
+// RecoverJournal applies the edits recorded in the journal of file, found by FindJournal,
+// and continues to record the next edits in this journal. It returns the number of edits recovered.
+// The document must have been loaded from file and not modified since.
+func (doc *Document) RecoverJournal(file string) (int, error) {
+	info, err := FindJournal(file)
+	if err != nil {
+		return 0, err
+	}
+	return doc.RecoverFoundJournal(file, info)
+}
+
+// RecoverFoundJournal is RecoverJournal with the journal already found by FindJournal,
+// which hashes the whole file and can be called in background.
+func (doc *Document) RecoverFoundJournal(file string, info *JournalInfo) (int, error) {
+	if info == nil {
+		return 0, fmt.Errorf("no journal found for %s", file)
+	}
+	if !info.Matches {
+		return 0, fmt.Errorf("%s was modified since the journal %s was created", file, info.Path)
+	}
+	journal, err := newJournal(file, doc.charset)
+	if err != nil {
+		return 0, err
+	}
+	if doc.journal != nil {
+		// nothing was recorded yet, the journal file must be kept
+		doc.journal.Close()
+		doc.journal = nil
+	}
+	count, size, err := replayJournal(info.Path, doc)
+	if err != nil {
+		return count, err
+	}
+	if err := journal.resume(size); err != nil {
+		return count, err
+	}
+	doc.journal = journal
+	return count, nil
+}
+
+// resetJournal starts a new journal after the document has been saved in file
+func (doc *Document) resetJournal(file string, charset string) {
+	if doc.journal == nil {
+		return
+	}
+	if path, err := GetJournalPath(file); err != nil || path != doc.journal.GetPath() {
+		// saved in another file, the edits are still unsaved in the journaled one
+		return
+	}
+	doc.checkJournal(doc.journal.reset(charset))
+}
+
+// checkJournal disables the journal if it cannot be written
+func (doc *Document) checkJournal(err error) {
+	if err != nil {
+		fmt.Printf("Journal disabled: %v\n", err)
+		doc.journal.Close()
+		doc.journal = nil
+	}
+}
+
+// GetHistory returns the undo/redo history of the document
+func (doc *Document) GetHistory() *History {
+	return doc.history
+}
+
+// Undo reverts the last edit, it returns false if there is nothing to undo
+func (doc *Document) Undo() bool {
+	if doc.readOnly {
+		return false
+	}
+	e := doc.history.popUndo()
+	if e == nil {
+		return false
+	}
+	if e.deletion {
+		doc.fireEdit(EVENT_INSERTED, e.globalIndex, e.globalIndex+e.length, doc.insertPieces(e.globalIndex, e.pieces))
+	} else {
+		doc.fireEdit(EVENT_REMOVED, e.globalIndex, e.globalIndex+e.length, doc.deleteRange(e.globalIndex, e.globalIndex+e.length))
+	}
+	return true
+}
+
+// Redo applies again the last undone edit, it returns false if there is nothing to redo
+func (doc *Document) Redo() bool {
+	if doc.readOnly {
+		return false
+	}
+	e := doc.history.popRedo()
+	if e == nil {
+		return false
+	}
+	if e.deletion {
+		doc.fireEdit(EVENT_REMOVED, e.globalIndex, e.globalIndex+e.length, doc.deleteRange(e.globalIndex, e.globalIndex+e.length))
+	} else {
+		doc.fireEdit(EVENT_INSERTED, e.globalIndex, e.globalIndex+e.length, doc.insertPieces(e.globalIndex, e.pieces))
+	}
+	return true
+}
+
+// lineChange is the result of updateLines: the removed lines starting at first were replaced by the added lines
+type lineChange struct {
+	first   int
+	removed int
+	added   int
+}
+
+// updateLines creates again the lines first to last (included) after an edit
+// inside these lines changing the length of the document by delta
+func (doc *Document) updateLines(first int, last int, delta int64) lineChange {
+	offsets := doc.offsets
+	lineCount := offsets.GetLineCount()
+	if first > 0 {
+		// a '\n' added after a CR makes a CRLF
+		previousEnd := offsets.GetOffset(first)
+		if doc.table.Bytes(previousEnd-1, previousEnd)[0] == '\r' {
+			first--
 		}
 	}
+	start := offsets.GetOffset(first)
+	end := offsets.GetOffset(last+1) + delta
 
-	if line.endsWithNewLine {
-		switch separator {
-		case AUTO, LF:
-			if _, err := out.WriteRune('\n'); err != nil {
-				return err
-			}
-		case CRLF:
-			if _, err := out.WriteString("\r\n"); err != nil {
-				return err
-			}
+	// the lines are created again when needed, only their lengths are computed
+	lengths := lineLengths(doc.table.Bytes(start, end), last == lineCount-1)
+
+	oldCount := last - first + 1
+	if len(lengths) == oldCount {
+		for i, length := range lengths {
+			offsets.SetLength(first+i, length)
 		}
+	} else {
+		offsets.Replace(first, oldCount, lengths)
+	}
+	doc.invalidLineBlocks(first, last, len(lengths) != oldCount)
+	if doc.totalLength >= 0 {
+		doc.totalLength += delta
+	}
+	change := lineChange{first: first, removed: oldCount, added: len(lengths)}
+	if VALIDATE_EDITS {
+		doc.checkEdit(change)
+	}
+	return change
+}
+
+// Additional Methods like `createTextLines`, etc. would need to be implemented in a similar fashion, but for brevity, only a subset of the Java methods have been translated.
+
+func (line *Line) dump(out io.Writer) {
+	for _, part := range line.parts {
+		fmt.Fprintln(out, part)
 	}
+}
 
-	return nil
+// writeTo writes the line in UTF-8, the conversion to the charset is done by the caller
+func (line *Line) writeTo(out *bufio.Writer, separator LineSeparator) error {
+	return line.writeUTF8To(out, separator)
 }
 
-func main() {
+// documentMain is the loading demo of the document, the application starts in EditorFrame.go
+func documentMain() {
 	fmt.Println("Document main:")
 	// Example usage
 	doc := NewDocument()
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// DocumentEventType is the kind of a DocumentEvent
+type DocumentEventType int
+
+const (
+	// EVENT_INSERTED is sent after text is inserted between Start and End
+	EVENT_INSERTED DocumentEventType = iota
+	// EVENT_REMOVED is sent after the text which was between Start and End is removed
+	EVENT_REMOVED
+	// EVENT_LINES_ADDED is sent after an edit creates LineCount lines, starting at FirstLine
+	EVENT_LINES_ADDED
+	// EVENT_LINES_REMOVED is sent after an edit removes the LineCount lines which started at FirstLine
+	EVENT_LINES_REMOVED
+	// EVENT_RELOADED is sent after the whole content is replaced, by a load or a close
+	EVENT_RELOADED
+	// EVENT_SAVED is sent after the document is saved in File
+	EVENT_SAVED
+)
+
+// String returns the name of the event type.
+func (t DocumentEventType) String() string {
+	switch t {
+	case EVENT_INSERTED:
+		return "inserted"
+	case EVENT_REMOVED:
+		return "removed"
+	case EVENT_LINES_ADDED:
+		return "lines added"
+	case EVENT_LINES_REMOVED:
+		return "lines removed"
+	case EVENT_RELOADED:
+		return "reloaded"
+	case EVENT_SAVED:
+		return "saved"
+	}
+	return fmt.Sprintf("DocumentEventType(%d)", int(t))
+}
+
+// DocumentEvent describes a change of a Document, only the fields of its type are set
+type DocumentEvent struct {
+	Type     DocumentEventType
+	Document *Document
+	// Start and End are the global indexes of the inserted text, or of the removed text before its removal
+	Start int64
+	End   int64
+	// FirstLine and LineCount are the added lines, or the removed lines before their removal
+	FirstLine int
+	LineCount int
+	File      string
+}
+
+// String returns a string representation of the event.
+func (e DocumentEvent) String() string {
+	switch e.Type {
+	case EVENT_INSERTED, EVENT_REMOVED:
+		return fmt.Sprintf("DocumentEvent [%s, start=%d, end=%d]", e.Type, e.Start, e.End)
+	case EVENT_LINES_ADDED, EVENT_LINES_REMOVED:
+		return fmt.Sprintf("DocumentEvent [%s, firstLine=%d, lineCount=%d]", e.Type, e.FirstLine, e.LineCount)
+	case EVENT_SAVED:
+		return fmt.Sprintf("DocumentEvent [%s, file=%s]", e.Type, e.File)
+	}
+	return fmt.Sprintf("DocumentEvent [%s]", e.Type)
+}
+
+// DocumentListener is notified of the changes of the documents it is added to
+type DocumentListener interface {
+	// DocumentChanged is called after the change, in the goroutine modifying the document, which holds its lock
+	DocumentChanged(event DocumentEvent)
+}
+
+// AddDocumentListener adds a listener notified of the changes of the document, in the order of addition.
+func (doc *Document) AddDocumentListener(listener DocumentListener) {
+	doc.listeners = append(doc.listeners, listener)
+}
+
+// RemoveDocumentListener removes a listener added by AddDocumentListener.
+func (doc *Document) RemoveDocumentListener(listener DocumentListener) {
+	if i := slices.Index(doc.listeners, listener); i >= 0 {
+		doc.listeners = slices.Delete(slices.Clone(doc.listeners), i, i+1)
+	}
+}
+
+// fireEvent notifies the listeners, a listener can add or remove listeners while notified
+func (doc *Document) fireEvent(event DocumentEvent) {
+	if len(doc.listeners) == 0 {
+		return
+	}
+	event.Document = doc
+	for _, listener := range doc.listeners {
+		listener.DocumentChanged(event)
+	}
+}
+
+// fireReloaded increments the version and notifies that the whole content was replaced
+func (doc *Document) fireReloaded() {
+	doc.version++
+	doc.savedVersion = doc.version
+	doc.fireEvent(DocumentEvent{Type: EVENT_RELOADED})
+}
+
+// fireSaved notifies that the document was saved in file, it is not modified anymore
+func (doc *Document) fireSaved(file string) {
+	doc.savedVersion = doc.version
+	doc.fireEvent(DocumentEvent{Type: EVENT_SAVED, File: file})
+}
+
+// fireEdit increments the version and notifies the insertion or the removal of the text between start and end,
+// and the lines added or removed by updateLines
+func (doc *Document) fireEdit(eventType DocumentEventType, start, end int64, change lineChange) {
+	doc.version++
+	if len(doc.listeners) == 0 {
+		return
+	}
+	doc.fireEvent(DocumentEvent{Type: eventType, Start: start, End: end})
+	if change.added > change.removed {
+		doc.fireEvent(DocumentEvent{Type: EVENT_LINES_ADDED, FirstLine: change.first + change.removed, LineCount: change.added - change.removed})
+	} else if change.added < change.removed {
+		doc.fireEvent(DocumentEvent{Type: EVENT_LINES_REMOVED, FirstLine: change.first + change.added, LineCount: change.removed - change.added})
+	}
+}
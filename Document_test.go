@@ -0,0 +1,39 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDocumentModified(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.txt")
+	doc := NewDocument()
+	doc.LoadFromString("hello\n", 0)
+	if doc.IsModified() {
+		t.Fatal("modified after the load")
+	}
+	doc.Insert(0, "x")
+	if !doc.IsModified() {
+		t.Fatal("not modified after an edit")
+	}
+	if err := doc.Save(file, UTF8, AUTO); err != nil {
+		t.Fatal(err)
+	}
+	if doc.IsModified() {
+		t.Fatal("modified after the save")
+	}
+	// the text appended by a follower comes from the file
+	doc.appendText("more\n")
+	if doc.IsModified() {
+		t.Fatal("modified by an append")
+	}
+	doc.Delete(0, 1)
+	doc.appendText("end\n")
+	if !doc.IsModified() {
+		t.Fatal("an append hides the edit")
+	}
+	doc.LoadFromString("other", 0)
+	if doc.IsModified() {
+		t.Fatal("modified after the reload")
+	}
+}
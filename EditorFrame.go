@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+// DIFF_TOO_LARGE is shown instead of the differences of texts longer than MAX_DIFF_SIZE
+const DIFF_TOO_LARGE = "The files are too large to be compared."
+
 type EditorFrame struct {
 	window             fyne.Window
 	labelFileName      *widget.Label
@@ -15,10 +24,22 @@ type EditorFrame struct {
 	labelCurrentLine   *widget.Label
 	labelCurrentColumn *widget.Label
 	labelCurrentIndex  *widget.Label
+	labelStatistics    *widget.Label
+	statistics         *StatisticsUpdater
 	editor             *TextEditorPanel
-	file               *os.File
+	file               string
 	charset            string
-	needSave           bool
+	needSave           bool // true when the document must be saved even if it is not modified, like after the removal of its file
+	watcher            *FileWatcher
+	follower           *Follower
+	followMenuItem     *fyne.MenuItem
+	tabWidthMenuItems  []*fyne.MenuItem
+	backup             BackupMode
+	backupMenuItems    []*fyne.MenuItem
+	loadingBox         *fyne.Container
+	progressBar        *widget.ProgressBar
+	labelProgress      *widget.Label
+	cancelLoading      context.CancelFunc
 	lineSeparator      string
 }
 
@@ -31,7 +52,18 @@ func NewEditorFrame(a fyne.App) *EditorFrame {
 	frame.labelCurrentLine = widget.NewLabel("")
 	frame.labelCurrentColumn = widget.NewLabel("")
 	frame.labelCurrentIndex = widget.NewLabel("")
+	frame.labelStatistics = widget.NewLabel("")
 	frame.editor = NewTextEditorPanel()
+	frame.progressBar = widget.NewProgressBar()
+	frame.labelProgress = widget.NewLabel("")
+	cancelButton := widget.NewButton("Cancel", func() {
+		if frame.cancelLoading != nil {
+			frame.cancelLoading()
+		}
+		frame.loadingBox.Hide()
+	})
+	frame.loadingBox = container.NewBorder(nil, nil, frame.labelProgress, cancelButton, frame.progressBar)
+	frame.loadingBox.Hide()
 
 	// Setup the main window
 	frame.window = a.NewWindow("GigaNotePad")
@@ -39,8 +71,9 @@ func NewEditorFrame(a fyne.App) *EditorFrame {
 		container.NewVBox(
 			frame.labelFileName,
 			container.NewHBox(frame.labelSelection, frame.labelCurrentIndex, frame.labelCurrentLine, frame.labelCurrentColumn),
+			frame.loadingBox,
 		),
-		nil, nil, nil,
+		frame.labelStatistics, nil, nil,
 		frame.editor,
 	))
 
@@ -57,14 +90,29 @@ func (frame *EditorFrame) setupMenu() {
 	menu := fyne.NewMainMenu(
 		fyne.NewMenu("File",
 			fyne.NewMenuItem("New", func() {
+				if frame.cancelLoading != nil {
+					frame.cancelLoading()
+				}
 				doc := NewDocument()
+				frame.file = ""
+				frame.labelFileName.SetText("")
 				frame.showNewEditor(doc)
 			}),
 			fyne.NewMenuItem("Open", func() { frame.openFile() }),
 			fyne.NewMenuItem("Save", func() { frame.saveFile() }),
+			frame.newBackupMenuItem(),
+			fyne.NewMenuItemSeparator(),
+			frame.newFollowMenuItem(),
 			fyne.NewMenuItemSeparator(),
 			fyne.NewMenuItem("Exit", func() { frame.window.Close() }),
 		),
+		fyne.NewMenu("Edit",
+			fyne.NewMenuItem("Undo", func() { frame.undo() }),
+			fyne.NewMenuItem("Redo", func() { frame.redo() }),
+		),
+		fyne.NewMenu("View",
+			frame.newTabWidthMenuItem(),
+		),
 		fyne.NewMenu("Help",
 			fyne.NewMenuItem("About", func() {
 				widget.ShowPopUp(widget.NewLabel("About GigaNotePad"), frame.window.Canvas())
@@ -74,45 +122,613 @@ func (frame *EditorFrame) setupMenu() {
 	frame.window.SetMainMenu(menu)
 }
 
+func (frame *EditorFrame) undo() {
+	doc := frame.editor.GetDocument()
+	if doc == nil {
+		return
+	}
+	doc.Lock()
+	defer doc.Unlock()
+	doc.Undo()
+}
+
+func (frame *EditorFrame) redo() {
+	doc := frame.editor.GetDocument()
+	if doc == nil {
+		return
+	}
+	doc.Lock()
+	defer doc.Unlock()
+	doc.Redo()
+}
+
 func (frame *EditorFrame) showNewEditor(doc *Document) {
-	// TODO
+	frame.closePagedDocument()
+	previous := frame.editor.GetDocument()
+	frame.editor.SetDocument(doc)
+	frame.listenTo(previous, doc)
+	frame.needSave = false
+}
+
+// replaceDocument shows another version of the edited document, see TextEditorPanel.ReplaceDocument
+func (frame *EditorFrame) replaceDocument(doc *Document) {
+	previous := frame.editor.GetDocument()
+	frame.editor.ReplaceDocument(doc)
+	frame.listenTo(previous, doc)
+}
+
+// listenTo updates the status labels and the statistics with the changes of doc instead of previous,
+// whose mapped file is released. The frame listens after the editor, which has then moved its cursor.
+func (frame *EditorFrame) listenTo(previous *Document, doc *Document) {
+	if previous != nil {
+		previous.Lock()
+		previous.RemoveDocumentListener(frame)
+		if frame.statistics != nil {
+			frame.statistics.Close()
+			frame.statistics = nil
+		}
+		if err := previous.Close(); err != nil {
+			fmt.Printf("Cannot close the previous document: %v\n", err)
+		}
+		previous.Unlock()
+	}
+	doc.Lock()
+	defer doc.Unlock()
+	doc.AddDocumentListener(frame)
+	frame.updateStatus()
+	frame.statistics = NewStatisticsUpdater(doc, fyne.Do, frame.showStatistics)
 }
 
-func (frame *EditorFrame) openFile() { /**
-	dialog := widget.NewFileOpen(func(reader fyne.URIReadCloser, _ error) {
+// DocumentChanged updates the status labels after a change of the document
+func (frame *EditorFrame) DocumentChanged(event DocumentEvent) {
+	frame.updateStatus()
+}
+
+// showStatistics shows the statistics in the status bar, they are computed in background for large documents
+func (frame *EditorFrame) showStatistics(stats Statistics) {
+	text := fmt.Sprintf("%d bytes, %d characters, %d words, %d lines (%d empty), longest line %d (%d characters), %s",
+		stats.Bytes, stats.Runes, stats.Words, stats.Lines, stats.EmptyLines, stats.LongestLine+1, stats.LongestLineLength, formatLineSeparators(stats))
+	if !stats.Complete {
+		text = "Counting... " + text
+	}
+	frame.labelStatistics.SetText(text)
+}
+
+// formatLineSeparators returns the ends of line used, with their number if there are several kinds
+func formatLineSeparators(stats Statistics) string {
+	counts := map[LineSeparator]int{LF: stats.LF, CRLF: stats.CRLF, CR: stats.CR}
+	var used []LineSeparator
+	for _, separator := range []LineSeparator{LF, CRLF, CR} {
+		if counts[separator] > 0 {
+			used = append(used, separator)
+		}
+	}
+	switch len(used) {
+	case 0:
+		return "no end of line"
+	case 1:
+		return used[0].String()
+	}
+	parts := make([]string, len(used))
+	for i, separator := range used {
+		parts[i] = fmt.Sprintf("%s %d", separator, counts[separator])
+	}
+	return strings.Join(parts, " / ")
+}
+
+// updateStatus shows the position of the cursor, the caller holds the lock of the document
+func (frame *EditorFrame) updateStatus() {
+	doc := frame.editor.GetDocument()
+	if doc == nil {
+		return
+	}
+	index := frame.editor.GetCursorIndex()
+	if paged := frame.editor.GetPagedDocument(); paged != nil {
+		// the index is in the whole text, the lines are counted in the window
+		frame.labelCurrentIndex.SetText(fmt.Sprintf("Index %d/%d", paged.GetWindowStart()+int64(frame.editor.cursorGlobalIndex), paged.GetLength()))
+	} else {
+		frame.labelCurrentIndex.SetText(fmt.Sprintf("Index %d/%d", frame.editor.cursorGlobalIndex, doc.GetTotalLength()))
+	}
+	frame.labelCurrentLine.SetText(fmt.Sprintf("Line %d/%d", index.GetLineIndex()+1, doc.GetLineCount()))
+	frame.labelCurrentColumn.SetText(fmt.Sprintf("Column %d", frame.editor.GetCursorColumn()+1))
+}
+
+func (frame *EditorFrame) openFile() {
+	d := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, frame.window)
+			return
+		}
 		if reader == nil {
 			return
 		}
-		defer reader.Close()
+		reader.Close()
+		// each file has its own charset
+		frame.loadFile(reader.URI().Path(), CHARSET_AUTO)
+	}, frame.window)
+	d.Show()
+}
 
-		data, err := ioutil.ReadAll(reader)
-		if err != nil {
-			widget.ShowPopUp(widget.NewLabel(fmt.Sprintf("Error reading file: %v", err)), frame.window.Canvas())
+// loadFile shows a read only preview of the beginning of the file, then loads the whole file in background,
+// showing the progress and allowing to cancel the loading. The complete document replaces the preview at the same position.
+// The charset is CHARSET_AUTO to detect it, or the charset of the document when the same file is reloaded.
+func (frame *EditorFrame) loadFile(file string, charset string) {
+	if frame.cancelLoading != nil {
+		frame.cancelLoading()
+	}
+	size := int64(0)
+	if info, err := os.Stat(file); err == nil {
+		size = info.Size()
+	}
+	if size > PAGED_FILE_SIZE {
+		frame.loadPagedFile(file, charset)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	frame.cancelLoading = cancel
+	frame.progressBar.SetValue(0)
+	frame.labelProgress.SetText("Opening " + filepath.Base(file))
+	frame.loadingBox.Show()
+
+	// the file is read in another goroutine, the frame is only modified by the functions passed to fyne.Do.
+	// They check ctx first: the loading can have been cancelled, or replaced by the loading of another file.
+	go func() {
+		preview := NewDocument()
+		if err := preview.PreLoadFrom(file, 0, charset, CHUNK_SIZE); err != nil {
+			fyne.Do(func() {
+				if ctx.Err() == nil {
+					frame.endLoading(cancel)
+					dialog.ShowError(err, frame.window)
+				}
+			})
 			return
 		}
-		frame.editor.SetText(string(data))
-		frame.labelFileName.SetText(filepath.Base(reader.URI().Path()))
-		frame.file, _ = os.Open(reader.URI().Path())
-		frame.needSave = false
+		// the same charset for the preview and the document, to keep the same indexes
+		charset = preview.GetCharset()
+		fyne.Do(func() {
+			if ctx.Err() == nil {
+				frame.stopFollowing()
+				frame.closeWatcher()
+				frame.labelFileName.SetText(filepath.Base(file) + " (preview)")
+				frame.showNewEditor(preview)
+			}
+		})
+
+		doc := NewDocument()
+		var err error
+		if size > MAPPED_FILE_SIZE {
+			err = doc.LoadMapped(file, 0, charset, CHUNK_SIZE)
+			if err == nil {
+				// the lines are found here rather than at the first display
+				doc.GetLineCount()
+			}
+		} else {
+			err = doc.LoadFromFile(ctx, file, charset, CHUNK_SIZE, func(progress LoadProgress) {
+				fyne.Do(func() {
+					if ctx.Err() == nil {
+						frame.progressBar.SetValue(progress.Fraction())
+						frame.labelProgress.SetText(fmt.Sprintf("%d bytes read, %d lines", progress.BytesRead, progress.LinesFound))
+					}
+				})
+			})
+		}
+		fyne.Do(func() {
+			if ctx.Err() != nil {
+				// the preview stays
+				doc.Close()
+				return
+			}
+			frame.endLoading(cancel)
+			if err != nil {
+				dialog.ShowError(err, frame.window)
+				return
+			}
+			frame.file = file
+			frame.charset = doc.GetCharset()
+			frame.labelFileName.SetText(filepath.Base(file))
+			frame.replaceDocument(doc)
+			frame.needSave = false
+			frame.watchFile(file)
+			frame.recoverJournal(file, doc)
+		})
+	}()
+}
+
+// endLoading hides the progress of the loading which ends, cancel is the cancel function of its context
+func (frame *EditorFrame) endLoading(cancel context.CancelFunc) {
+	cancel()
+	frame.cancelLoading = nil
+	frame.loadingBox.Hide()
+}
+
+// loadPagedFile opens a file larger than PAGED_FILE_SIZE as a paged document:
+// only the pages around the visible text are loaded, the next pages are loaded when scrolling
+func (frame *EditorFrame) loadPagedFile(file string, charset string) {
+	paged, err := OpenPagedDocument(file, charset, 0, CHUNK_SIZE)
+	if err != nil {
+		dialog.ShowError(err, frame.window)
+		return
+	}
+	frame.stopFollowing()
+	frame.closeWatcher()
+	frame.closePagedDocument()
+	frame.file = file
+	frame.charset = paged.GetCharset()
+	frame.labelFileName.SetText(filepath.Base(file) + " (paged)")
+	previous := frame.editor.GetDocument()
+	frame.editor.SetPagedDocument(paged)
+	frame.listenTo(previous, paged.GetDocument())
+	frame.needSave = false
+	frame.watchFile(file)
+}
+
+// closePagedDocument closes the file of the paged document being edited, if any
+func (frame *EditorFrame) closePagedDocument() {
+	paged := frame.editor.GetPagedDocument()
+	if paged == nil {
+		return
+	}
+	doc := paged.GetDocument()
+	doc.Lock()
+	defer doc.Unlock()
+	if err := paged.Close(); err != nil {
+		fmt.Printf("Cannot close %s: %v\n", paged.GetFileName(), err)
+	}
+}
+
+// newFollowMenuItem creates the menu item switching the follow mode, to read growing log files
+func (frame *EditorFrame) newFollowMenuItem() *fyne.MenuItem {
+	frame.followMenuItem = fyne.NewMenuItem("Follow", func() {
+		if frame.follower != nil {
+			frame.stopFollowing()
+			frame.watchFile(frame.file)
+		} else {
+			frame.startFollowing()
+		}
+	})
+	return frame.followMenuItem
+}
+
+// newBackupMenuItem creates the menu choosing how the previous version of a file is kept when it is saved
+func (frame *EditorFrame) newBackupMenuItem() *fyne.MenuItem {
+	item := fyne.NewMenuItem("Backup", nil)
+	item.ChildMenu = fyne.NewMenu("")
+	for _, mode := range []BackupMode{BACKUP_NONE, BACKUP_TILDE, BACKUP_BAK, BACKUP_NUMBERED} {
+		backupItem := fyne.NewMenuItem(getBackupModeLabel(mode), func() {
+			frame.setBackupMode(mode)
+		})
+		backupItem.Checked = mode == frame.backup
+		item.ChildMenu.Items = append(item.ChildMenu.Items, backupItem)
+		frame.backupMenuItems = append(frame.backupMenuItems, backupItem)
+	}
+	return item
+}
+
+// getBackupModeLabel returns the label of the menu item of a backup mode
+func getBackupModeLabel(mode BackupMode) string {
+	switch mode {
+	case BACKUP_TILDE:
+		return "file~"
+	case BACKUP_BAK:
+		return "file.bak"
+	case BACKUP_NUMBERED:
+		return fmt.Sprintf("file.~1~ to file.~%d~", DEFAULT_BACKUP_COUNT)
+	}
+	return "None"
+}
+
+// setBackupMode changes how the previous version of the file is kept by the next saves
+func (frame *EditorFrame) setBackupMode(mode BackupMode) {
+	frame.backup = mode
+	for _, item := range frame.backupMenuItems {
+		item.Checked = item.Label == getBackupModeLabel(mode)
+	}
+	if menu := frame.window.MainMenu(); menu != nil {
+		menu.Refresh()
+	}
+}
+
+// newTabWidthMenuItem creates the menu choosing the number of columns between two tab stops
+func (frame *EditorFrame) newTabWidthMenuItem() *fyne.MenuItem {
+	item := fyne.NewMenuItem("Tab Width", nil)
+	item.ChildMenu = fyne.NewMenu("")
+	for _, tabWidth := range []int{2, 4, 8} {
+		tabWidthItem := fyne.NewMenuItem(fmt.Sprintf("%d", tabWidth), func() {
+			frame.setTabWidth(tabWidth)
+		})
+		tabWidthItem.Checked = tabWidth == frame.editor.GetColumnModel().GetTabWidth()
+		item.ChildMenu.Items = append(item.ChildMenu.Items, tabWidthItem)
+		frame.tabWidthMenuItems = append(frame.tabWidthMenuItems, tabWidthItem)
+	}
+	return item
+}
+
+// setTabWidth changes the number of columns between two tab stops, the column of the cursor changes with it
+func (frame *EditorFrame) setTabWidth(tabWidth int) {
+	frame.editor.SetTabWidth(tabWidth)
+	for _, item := range frame.tabWidthMenuItems {
+		item.Checked = item.Label == fmt.Sprintf("%d", tabWidth)
+	}
+	if menu := frame.window.MainMenu(); menu != nil {
+		menu.Refresh()
+	}
+	if doc := frame.editor.GetDocument(); doc != nil {
+		doc.Lock()
+		frame.updateStatus()
+		doc.Unlock()
+	}
+}
+
+// updateFollowMenuItem checks the follow menu item in follow mode
+func (frame *EditorFrame) updateFollowMenuItem() {
+	frame.followMenuItem.Checked = frame.follower != nil
+	if menu := frame.window.MainMenu(); menu != nil {
+		menu.Refresh()
+	}
+}
+
+// startFollowing appends to the document the text added to the opened file
+func (frame *EditorFrame) startFollowing() {
+	doc := frame.editor.GetDocument()
+	// the window of a paged document is not the end of the file
+	if frame.file == "" || doc == nil || frame.editor.GetPagedDocument() != nil {
+		return
+	}
+	doc.Lock()
+	if doc.IsReadOnly() {
+		doc.Unlock()
+		return
+	}
+	follower, err := doc.Follow(frame.file, fyne.Do, func(truncated bool) {
+		frame.editor.DocumentAppended(truncated)
+	})
+	doc.Unlock()
+	if err != nil {
+		dialog.ShowError(err, frame.window)
+		return
+	}
+	// the modifications are expected, don't ask to reload
+	frame.closeWatcher()
+	frame.follower = follower
+	frame.editor.SetFollowing(true)
+	frame.updateFollowMenuItem()
+}
+
+func (frame *EditorFrame) stopFollowing() {
+	if frame.follower == nil {
+		return
+	}
+	frame.follower.Stop()
+	frame.follower = nil
+	frame.editor.SetFollowing(false)
+	frame.updateFollowMenuItem()
+}
+
+// watchFile watches the modifications of the opened file by other programs
+func (frame *EditorFrame) watchFile(file string) {
+	frame.closeWatcher()
+	watcher, err := NewFileWatcher(file, func(state FileState) {
+		fyne.Do(func() {
+			frame.fileChanged(file, state)
+		})
+	})
+	if err != nil {
+		fmt.Printf("Cannot watch %s: %v\n", file, err)
+		return
+	}
+	frame.watcher = watcher
+}
+
+func (frame *EditorFrame) closeWatcher() {
+	if frame.watcher != nil {
+		frame.watcher.Close()
+		frame.watcher = nil
+	}
+}
+
+// hasUnsavedChanges returns true if the document was edited since it was loaded or saved
+func (frame *EditorFrame) hasUnsavedChanges() bool {
+	if frame.needSave {
+		return true
+	}
+	doc := frame.editor.GetDocument()
+	if doc == nil {
+		return false
+	}
+	doc.Lock()
+	defer doc.Unlock()
+	if paged := frame.editor.GetPagedDocument(); paged != nil {
+		return paged.IsModified()
+	}
+	return doc.IsModified()
+}
+
+// fileChanged reloads the file modified by another program, or asks what to do if the document has unsaved edits
+func (frame *EditorFrame) fileChanged(file string, state FileState) {
+	if file != frame.file {
+		return
+	}
+	if !state.Exists {
+		frame.needSave = true
+		dialog.ShowInformation("File removed", filepath.Base(file)+" was removed by another program, the document can be saved to create it again.", frame.window)
+		return
+	}
+	if !frame.hasUnsavedChanges() {
+		frame.reloadFile()
+		return
+	}
+	message := widget.NewLabel(filepath.Base(file) + " was modified by another program and the document has unsaved changes.")
+	d := dialog.NewCustomWithoutButtons("File modified", message, frame.window)
+	d.SetButtons([]fyne.CanvasObject{
+		widget.NewButton("Reload", func() {
+			d.Hide()
+			frame.reloadFile()
+		}),
+		widget.NewButton("Keep mine", func() {
+			d.Hide()
+		}),
+		widget.NewButton("Diff", func() {
+			d.Hide()
+			frame.showDiff(file, func() {
+				frame.fileChanged(file, state)
+			})
+		}),
+	})
+	d.Show()
+}
+
+// reloadFile loads again the opened file, dropping the unsaved changes
+func (frame *EditorFrame) reloadFile() {
+	if doc := frame.editor.GetDocument(); doc != nil {
+		doc.Lock()
+		doc.DisableJournal()
+		doc.Unlock()
+	}
+	frame.needSave = false
+	frame.loadFile(frame.file, frame.charset)
+}
+
+// showDiff shows the differences between the file and the document, onClosed is called when the diff is closed.
+// The file is read and compared in background.
+func (frame *EditorFrame) showDiff(file string, onClosed func()) {
+	if frame.editor.GetPagedDocument() != nil {
+		frame.showDiffText(DIFF_TOO_LARGE, onClosed)
+		return
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		d := dialog.NewError(err, frame.window)
+		d.SetOnClosed(onClosed)
+		d.Show()
+		return
+	}
+	document := frame.editor.GetDocument()
+	document.Lock()
+	doc := document.Snapshot()
+	document.Unlock()
+	if info.Size() > MAX_DIFF_SIZE || doc.GetTotalLength() > MAX_DIFF_SIZE {
+		doc.Release()
+		frame.showDiffText(DIFF_TOO_LARGE, onClosed)
+		return
+	}
+	go func() {
+		defer doc.Release()
+		text, err := diffFile(file, doc)
+		fyne.Do(func() {
+			if err != nil {
+				d := dialog.NewError(err, frame.window)
+				d.SetOnClosed(onClosed)
+				d.Show()
+				return
+			}
+			frame.showDiffText(text, onClosed)
+		})
+	}()
+}
+
+// diffFile returns the unified diff between the file and the snapshot of the document
+func diffFile(file string, doc *Snapshot) (string, error) {
+	saved := NewDocument()
+	if err := saved.LoadFromFile(context.Background(), file, doc.GetCharset(), CHUNK_SIZE, nil); err != nil {
+		return "", err
+	}
+	if saved.GetTotalLength() > MAX_DIFF_SIZE {
+		// decoded from a charset using fewer bytes than UTF-8
+		return DIFF_TOO_LARGE, nil
+	}
+	diff, ok := DiffLines(splitLinesAfter(saved.GetText(0, saved.GetTotalLength())), splitLinesAfter(doc.GetText(0, doc.GetTotalLength())))
+	if !ok {
+		return "The files are too different to be compared.", nil
+	}
+	return FormatUnifiedDiff(diff, filepath.Base(file)+" (file)", filepath.Base(file)+" (document)", 3), nil
+}
+
+// showDiffText shows the differences computed by showDiff
+func (frame *EditorFrame) showDiffText(text string, onClosed func()) {
+	grid := widget.NewTextGridFromString(text)
+	scroll := container.NewScroll(grid)
+	scroll.SetMinSize(fyne.NewSize(800, 500))
+	d := dialog.NewCustom("Differences", "Close", scroll, frame.window)
+	d.SetOnClosed(onClosed)
+	d.Show()
+}
+
+// recoverJournal proposes to recover the unsaved edits of the file if the editor crashed, then records the next edits.
+// The journal is searched in background, as the file is hashed to check that the journal is about its content.
+func (frame *EditorFrame) recoverJournal(file string, doc *Document) {
+	go func() {
+		info, err := FindJournal(file)
+		fyne.Do(func() {
+			if frame.editor.GetDocument() != doc {
+				// another document was opened meanwhile
+				return
+			}
+			if err != nil {
+				fmt.Printf("Cannot read the journal of %s: %v\n", file, err)
+			}
+			frame.proposeRecovery(file, doc, info)
+		})
+	}()
+}
+
+// proposeRecovery asks if the edits of the journal found by recoverJournal must be recovered
+func (frame *EditorFrame) proposeRecovery(file string, doc *Document, info *JournalInfo) {
+	enable := func() {
+		doc.Lock()
+		defer doc.Unlock()
+		if err := doc.EnableJournal(file); err != nil {
+			fmt.Printf("Cannot enable the journal of %s: %v\n", file, err)
+		}
+	}
+	if info == nil || !info.Matches {
+		enable()
+		return
+	}
+	message := fmt.Sprintf("Unsaved changes of %s from %s were found.\nDo you want to recover them?", filepath.Base(file), info.ModTime.Format(time.DateTime))
+	dialog.ShowConfirm("Recover unsaved changes", message, func(recover bool) {
+		if !recover {
+			os.Remove(info.Path)
+			enable()
+			return
+		}
+		doc.Lock()
+		_, err := doc.RecoverFoundJournal(file, info)
+		doc.Unlock()
+		if err != nil {
+			dialog.ShowError(err, frame.window)
+		}
+		frame.needSave = true
 	}, frame.window)
-	dialog.SetFilter(storage.NewExtensionFileFilter([]string{".txt", ".md", ".go", ".java", ".py"}))
-	dialog.Show()*/
 }
 
 func (frame *EditorFrame) saveFile() {
-	if frame.file == nil {
+	if frame.file == "" {
 		frame.saveFileAs()
 		return
 	}
-	/**
-	err := frame.editor.GetDocument().Save()
+	doc := frame.editor.GetDocument()
+	paged := frame.editor.GetPagedDocument()
+	options := SaveOptions{Backup: frame.backup}
+	save := func() error {
+		doc.Lock()
+		defer doc.Unlock()
+		if paged != nil {
+			return paged.Save(frame.file, options)
+		}
+		return doc.SaveWithOptions(frame.file, frame.charset, AUTO, options)
+	}
+	var err error
+	if frame.watcher != nil {
+		// not an external modification
+		err = frame.watcher.Write(save)
+	} else {
+		err = save()
+	}
 	if err != nil {
-		widget.ShowPopUp(widget.NewLabel(fmt.Sprintf("Error saving file: %v", err)), frame.window.Canvas())
+		dialog.ShowError(err, frame.window)
 	} else {
 		frame.needSave = false
 	}
-
-	*/
 }
 
 func (frame *EditorFrame) saveFileAs() { /**
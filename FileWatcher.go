@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WATCH_DELAY is the time without events waited before checking a modified file, writers often emit many events
+const WATCH_DELAY = 300 * time.Millisecond
+
+// FileState identifies a version of a file
+type FileState struct {
+	Exists  bool
+	Size    int64
+	ModTime time.Time
+	// Hash is the SHA-256 of the content, empty if not computed
+	Hash string
+	// info identifies the file itself, a file replaced by a rename is another file
+	info os.FileInfo
+}
+
+// String returns a string representation of the state.
+func (s FileState) String() string {
+	return fmt.Sprintf("FileState [exists=%t, size=%d, modTime=%s, hash=%s]", s.Exists, s.Size, s.ModTime, s.Hash)
+}
+
+// sameStat returns true if the file, its size and its modification time are the same
+func (s FileState) sameStat(other FileState) bool {
+	if s.Exists != other.Exists {
+		return false
+	}
+	if !s.Exists {
+		return true
+	}
+	return s.Size == other.Size && s.ModTime.Equal(other.ModTime) && os.SameFile(s.info, other.info)
+}
+
+// getFileState returns the state of the file, without its hash
+func getFileState(file string) (FileState, error) {
+	info, err := os.Stat(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return FileState{}, nil
+	}
+	if err != nil {
+		return FileState{}, err
+	}
+	return FileState{Exists: true, Size: info.Size(), ModTime: info.ModTime(), info: info}, nil
+}
+
+// FileWatcher notifies the modifications of a file by other processes.
+// The directory of the file is watched, to follow the files replaced by a rename.
+// The size, modification time and identity of the file are compared first: the content is only hashed when the file
+// keeps its size, to ignore a modification only changing the modification time. The hash of the known version
+// is computed in background when the watcher starts and after each Write.
+type FileWatcher struct {
+	file     string
+	watcher  *fsnotify.Watcher
+	onChange func(state FileState)
+	mutex    sync.Mutex
+	state    FileState
+	timer    *time.Timer
+	closed   bool
+}
+
+// NewFileWatcher watches file, onChange is called from another goroutine with the new state of the file
+// when it is modified or removed.
+func NewFileWatcher(file string, onChange func(state FileState)) (*FileWatcher, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	state, err := getFileState(file)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	w := &FileWatcher{file: file, watcher: watcher, onChange: onChange, state: state}
+	w.timer = time.AfterFunc(WATCH_DELAY, w.check)
+	w.hashState(state)
+	go w.run()
+	return w, nil
+}
+
+// GetFile returns the absolute path of the watched file.
+func (w *FileWatcher) GetFile() string {
+	return w.file
+}
+
+func (w *FileWatcher) run() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) == w.file {
+				w.timer.Reset(WATCH_DELAY)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			fmt.Printf("Error watching %s: %v\n", w.file, err)
+		}
+	}
+}
+
+// hashState computes in background the hash of the file in the given state,
+// it is kept if the file is still in this state
+func (w *FileWatcher) hashState(state FileState) {
+	if !state.Exists {
+		return
+	}
+	go func() {
+		hash, err := hashFile(w.file)
+		if err != nil {
+			fmt.Printf("Cannot hash %s: %v\n", w.file, err)
+			return
+		}
+		if after, err := getFileState(w.file); err != nil || !after.sameStat(state) {
+			// modified while hashing, the next check compares the stat
+			return
+		}
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		if w.state.Hash == "" && w.state.sameStat(state) {
+			w.state.Hash = hash
+		}
+	}()
+}
+
+// check compares the file with the last known state and notifies a change.
+// The file is hashed without holding the lock, a Write during the check is not notified.
+func (w *FileWatcher) check() {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return
+	}
+	previous := w.state
+	w.mutex.Unlock()
+
+	state, err := getFileState(w.file)
+	if err != nil {
+		fmt.Printf("Cannot check %s: %v\n", w.file, err)
+		return
+	}
+	if state.sameStat(previous) {
+		return
+	}
+	changed := true
+	if state.Exists && previous.Exists && state.Size == previous.Size && previous.Hash != "" {
+		// the same size, maybe only touched or replaced by the same content
+		if state.Hash, err = hashFile(w.file); err != nil {
+			fmt.Printf("Cannot check %s: %v\n", w.file, err)
+			return
+		}
+		changed = state.Hash != previous.Hash
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed || !w.state.sameStat(previous) {
+		// written meanwhile
+		return
+	}
+	w.state = state
+	if state.Hash == "" {
+		w.hashState(state)
+	}
+	if changed {
+		go w.onChange(state)
+	}
+}
+
+// Write calls write, which modifies the file, without notifying this modification.
+// The new state of the file is read after write, its hash is computed in background.
+func (w *FileWatcher) Write(write func() error) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	err := write()
+	state, stateErr := getFileState(w.file)
+	if stateErr != nil {
+		fmt.Printf("Cannot check %s: %v\n", w.file, stateErr)
+	}
+	w.state = state
+	w.hashState(state)
+	return err
+}
+
+// Close stops watching the file.
+func (w *FileWatcher) Close() error {
+	w.mutex.Lock()
+	w.closed = true
+	w.timer.Stop()
+	w.mutex.Unlock()
+	return w.watcher.Close()
+}
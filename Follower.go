@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
+)
+
+// FOLLOW_INTERVAL is the delay between two checks of a followed file
+const FOLLOW_INTERVAL = 500 * time.Millisecond
+
+// Follower appends to a document the bytes added to the file it was loaded from, like tail -F.
+// When the file is replaced (rotated), the end of the previous file is read then the new file is followed from its start.
+// When the file is truncated, the previous content is not available anymore and the document is reloaded from the start.
+type Follower struct {
+	doc     *Document
+	file    string
+	charset string
+	// in is the followed file, offset the number of bytes of in already appended
+	in       *os.File
+	offset   int64
+	decoder  *streamDecoder
+	onAppend func(truncated bool)
+	// runner runs the modifications of the document, nil to run them in the goroutine of the follower
+	runner   func(f func())
+	stopped  atomic.Bool
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Follow appends to the document the bytes added to the file it was loaded from.
+// The file is read in another goroutine, the text is appended by a function passed to run,
+// which runs it in the goroutine owning the document, like fyne.Do. If run is nil, the text is appended
+// from the goroutine of the follower. onAppend, if not nil, is called the same way after text is appended,
+// truncated is true if the document was reloaded. The functions passed to run do nothing after Stop.
+// The document must not be modified by other means while it is followed. The follower locks the document
+// to append text, its listeners are notified from the goroutine running the append.
+func (doc *Document) Follow(file string, run func(f func()), onAppend func(truncated bool)) (*Follower, error) {
+	if _, err := GetEncoding(doc.charset); err != nil {
+		return nil, err
+	}
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	f := &Follower{
+		doc:      doc,
+		file:     file,
+		charset:  doc.charset,
+		in:       in,
+		offset:   doc.fileLength,
+		decoder:  newStreamDecoder(doc.charset),
+		onAppend: onAppend,
+		runner:   run,
+		stop:     make(chan struct{}),
+	}
+	go f.run()
+	return f, nil
+}
+
+func (f *Follower) run() {
+	defer f.in.Close()
+	ticker := time.NewTicker(FOLLOW_INTERVAL)
+	defer ticker.Stop()
+	for {
+		appended, truncated, err := f.poll()
+		if err != nil {
+			fmt.Printf("Error following %s: %v\n", f.file, err)
+		}
+		if (appended || truncated) && f.onAppend != nil {
+			f.notify(truncated)
+		}
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll appends the new content of the file, it returns true if text was appended
+// and true if the document was reloaded after a truncation
+func (f *Follower) poll() (bool, bool, error) {
+	info, err := f.in.Stat()
+	if err != nil {
+		return false, false, err
+	}
+	truncated := false
+	if info.Size() < f.offset {
+		f.apply(func() {
+			f.doc.loadFromBytes(nil, f.doc.maxPartSize)
+			f.doc.fireReloaded()
+		})
+		f.offset = 0
+		f.decoder = newStreamDecoder(f.charset)
+		truncated = true
+	}
+	appended, err := f.readAvailable()
+	if err != nil {
+		return appended, truncated, err
+	}
+
+	// a rotation replaces the file, the previous one has been read until its end
+	current, err := os.Stat(f.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		// being rotated
+		return appended, truncated, nil
+	}
+	if err != nil {
+		return appended, truncated, err
+	}
+	if os.SameFile(info, current) {
+		return appended, truncated, nil
+	}
+	in, err := os.Open(f.file)
+	if err != nil {
+		return appended, truncated, err
+	}
+	f.in.Close()
+	f.in = in
+	f.offset = 0
+	f.decoder = newStreamDecoder(f.charset)
+	appendedAfterRotation, err := f.readAvailable()
+	return appended || appendedAfterRotation, truncated, err
+}
+
+// readAvailable appends the bytes of the file after offset
+func (f *Follower) readAvailable() (bool, error) {
+	buffer := make([]byte, CHUNK_SIZE)
+	appended := false
+	for {
+		n, err := f.in.ReadAt(buffer, f.offset)
+		if n > 0 {
+			data := buffer[:n]
+			if f.offset == 0 {
+				// a new file can start with a byte order mark
+				if bom := getBOM(f.charset); bom != nil && bytes.HasPrefix(data, bom) {
+					data = data[len(bom):]
+				}
+			}
+			f.offset += int64(n)
+			text, decodeErr := f.decoder.decode(data)
+			if decodeErr != nil {
+				return appended, decodeErr
+			}
+			offset := f.offset
+			f.apply(func() {
+				f.doc.fileLength = offset
+				if len(text) > 0 {
+					f.doc.appendText(text)
+				}
+			})
+			appended = appended || len(text) > 0
+		}
+		if err == io.EOF {
+			return appended, nil
+		}
+		if err != nil {
+			return appended, err
+		}
+	}
+}
+
+// apply runs modify with the runner, holding the lock of the document, unless the follower is stopped
+func (f *Follower) apply(modify func()) {
+	f.schedule(func() {
+		f.doc.Lock()
+		defer f.doc.Unlock()
+		if !f.stopped.Load() {
+			modify()
+		}
+	})
+}
+
+// notify calls onAppend with the runner, unless the follower is stopped
+func (f *Follower) notify(truncated bool) {
+	f.schedule(func() {
+		if !f.stopped.Load() {
+			f.onAppend(truncated)
+		}
+	})
+}
+
+// schedule runs run with the runner, or in the goroutine of the follower if there is no runner
+func (f *Follower) schedule(run func()) {
+	if f.runner == nil {
+		run()
+	} else {
+		f.runner(run)
+	}
+}
+
+// Stop stops following the file, it doesn't wait for the goroutine of the follower and can be called
+// with the lock of the document held. The modifications of the document are checked with its lock held:
+// once Stop returned, the document is not modified anymore after the lock is released by a modification in progress.
+func (f *Follower) Stop() {
+	f.stopped.Store(true)
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+}
+
+// streamDecoder decodes text received by blocks, keeping the bytes of a character cut between two blocks
+type streamDecoder struct {
+	// transformer is nil for UTF-8
+	transformer transform.Transformer
+	pending     []byte
+}
+
+func newStreamDecoder(charset string) *streamDecoder {
+	d := &streamDecoder{}
+	if enc, err := GetEncoding(charset); err == nil && enc != nil {
+		d.transformer = enc.NewDecoder()
+	}
+	return d
+}
+
+// decode returns the UTF-8 text of the complete characters of pending and data
+func (d *streamDecoder) decode(data []byte) (string, error) {
+	src := append(d.pending, data...)
+	if d.transformer == nil {
+		// keep an incomplete rune at the end
+		for i := len(src) - 1; i >= 0 && i >= len(src)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(src[i]) {
+				if !utf8.FullRune(src[i:]) {
+					d.pending = append([]byte(nil), src[i:]...)
+					return string(src[:i]), nil
+				}
+				break
+			}
+		}
+		d.pending = nil
+		return string(src), nil
+	}
+
+	var out []byte
+	dst := make([]byte, 2*len(src)+utf8.UTFMax)
+	for {
+		nDst, nSrc, err := d.transformer.Transform(dst, src, false)
+		out = append(out, dst[:nDst]...)
+		src = src[nSrc:]
+		if err == transform.ErrShortDst {
+			continue
+		}
+		if err != nil && err != transform.ErrShortSrc {
+			return "", err
+		}
+		break
+	}
+	d.pending = append([]byte(nil), src...)
+	return string(out), nil
+}
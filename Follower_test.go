@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// checkFollowed fails the test if the text or the lines of the followed document are not the ones of expected
+func checkFollowed(t *testing.T, doc *Document, expected string) {
+	t.Helper()
+	reference := NewDocument()
+	reference.LoadFromString(expected, 4)
+	doc.Lock()
+	defer doc.Unlock()
+	if got := doc.GetText(0, doc.GetTotalLength()); got != expected || doc.GetLineCount() != reference.GetLineCount() {
+		t.Fatalf("text %q (%d lines), expected %q", got, doc.GetLineCount(), expected)
+	}
+	for i := 0; i < reference.GetLineCount(); i++ {
+		if doc.GetLine(i).String() != reference.GetLine(i).String() {
+			t.Fatalf("line %d: %v, expected %v", i, doc.GetLine(i), reference.GetLine(i))
+		}
+	}
+}
+
+// appendToFile appends text to file, failing the test on error
+func appendToFile(t *testing.T, file string, text string) {
+	t.Helper()
+	out, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if _, err := out.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowerRotationAndTruncation(t *testing.T) {
+	for _, mapped := range []bool{false, true} {
+		file := filepath.Join(t.TempDir(), "app.log")
+		if err := os.WriteFile(file, []byte("l1\nl2\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		doc := NewDocument()
+		var err error
+		if mapped {
+			err = doc.LoadMapped(file, 0, CHARSET_AUTO, 4)
+		} else {
+			err = doc.LoadFrom(file, 0, CHARSET_AUTO, 4)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		appended := make(chan bool, 100)
+		follower, err := doc.Follow(file, nil, func(truncated bool) {
+			appended <- truncated
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// wait returns true if the document was reloaded
+		wait := func() bool {
+			t.Helper()
+			select {
+			case truncated := <-appended:
+				return truncated
+			case <-time.After(3 * time.Second):
+				t.Fatal("nothing appended")
+			}
+			return false
+		}
+
+		// an end of line and a rune cut between two polls
+		appendToFile(t, file, "l3 é\r")
+		wait()
+		appendToFile(t, file, "\nl4 \xc3")
+		wait()
+		checkFollowed(t, doc, "l1\nl2\nl3 é\r\nl4 ")
+		appendToFile(t, file, "\xa9\n")
+		wait()
+		checkFollowed(t, doc, "l1\nl2\nl3 é\r\nl4 é\n")
+
+		// the end of the rotated file, then the new file
+		appendToFile(t, file, "last\n")
+		if err := os.Rename(file, file+".1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte("new1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		wait()
+		checkFollowed(t, doc, "l1\nl2\nl3 é\r\nl4 é\nlast\nnew1\n")
+
+		// the document is reloaded from the start of the truncated file
+		if err := os.WriteFile(file, []byte("t\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if !wait() {
+			t.Fatal("truncation not detected")
+		}
+		checkFollowed(t, doc, "t\n")
+		appendToFile(t, file, "u\n")
+		wait()
+		checkFollowed(t, doc, "t\nu\n")
+		follower.Stop()
+		doc.Close()
+	}
+}
+
+func TestFollowerRunner(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(file, []byte("l1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := NewDocument()
+	if err := doc.LoadFrom(file, 0, CHARSET_AUTO, 4); err != nil {
+		t.Fatal(err)
+	}
+	// the goroutine of the test runs the modifications, as the UI goroutine does
+	queue := make(chan func(), 100)
+	appended := make(chan bool, 100)
+	follower, err := doc.Follow(file, func(f func()) { queue <- f }, func(truncated bool) { appended <- truncated })
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendToFile(t, file, "l2\n")
+	for done := false; !done; {
+		select {
+		case run := <-queue:
+			run()
+		case <-appended:
+			done = true
+		case <-time.After(3 * time.Second):
+			t.Fatal("nothing appended")
+		}
+	}
+	checkFollowed(t, doc, "l1\nl2\n")
+
+	// the modifications queued before Stop are dropped
+	appendToFile(t, file, "l3\n")
+	time.Sleep(2 * FOLLOW_INTERVAL)
+	follower.Stop()
+	for len(queue) > 0 {
+		(<-queue)()
+	}
+	checkFollowed(t, doc, "l1\nl2\n")
+	if doc.IsModified() {
+		t.Fatal("modified by the follower")
+	}
+}
+
+func TestFollowerStopLocked(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(file, []byte("l1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := NewDocument()
+	if err := doc.LoadFrom(file, 0, CHARSET_AUTO, 4); err != nil {
+		t.Fatal(err)
+	}
+	follower, err := doc.Follow(file, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the follower waits for the lock to append
+	doc.Lock()
+	appendToFile(t, file, "l2\n")
+	time.Sleep(2 * FOLLOW_INTERVAL)
+	stopped := make(chan struct{})
+	go func() {
+		follower.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Stop blocked by the lock of the document")
+	}
+	doc.Unlock()
+	time.Sleep(2 * FOLLOW_INTERVAL)
+	checkFollowed(t, doc, "l1\n")
+}
+
+func TestStreamDecoder(t *testing.T) {
+	decoder := newStreamDecoder("UTF-16LE")
+	s1, _ := decoder.decode([]byte{'a', 0, 'b'})
+	s2, _ := decoder.decode([]byte{0, 0xe9})
+	s3, _ := decoder.decode([]byte{0})
+	if s1 != "a" || s1+s2+s3 != "abé" {
+		t.Fatalf("%q %q %q", s1, s2, s3)
+	}
+	decoder = newStreamDecoder(UTF8)
+	s1, _ = decoder.decode([]byte("a\xe2\x82"))
+	s2, _ = decoder.decode([]byte("\xac"))
+	if s1 != "a" || s2 != "€" {
+		t.Fatalf("%q %q", s1, s2)
+	}
+}
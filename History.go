@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// DEFAULT_HISTORY_MEMORY_LIMIT is the default size of the inserted text referenced by the history
+const DEFAULT_HISTORY_MEMORY_LIMIT = 512 * 1024 * 1024
+
+// HISTORY_COMPACTION_SIZE is the minimum size of the inserted text of the dropped steps to compact the add buffer
+const HISTORY_COMPACTION_SIZE = 16 * 1024 * 1024
+
+// COALESCE_DELAY is the maximum delay between two edits merged in one undo step
+const COALESCE_DELAY = 2 * time.Second
+
+// historyEdit is an insertion or a deletion recorded by the History.
+// The text is not copied, the edit references the pieces of the piece table containing it.
+type historyEdit struct {
+	deletion    bool
+	globalIndex int64
+	length      int64
+	pieces      []piece
+	// memory is the length of the pieces of the add buffer
+	memory int64
+	time   time.Time
+	// closed is true if the next edits can't be merged into this one
+	closed bool
+}
+
+// History records the edits of a Document to undo and redo them.
+// Consecutive typing (or deleting) is merged into one step.
+// The text of the edits is in the buffers of the piece table: the original text costs nothing more,
+// but the inserted text stays in the add buffer while the history references it.
+// The memory limit bounds the size of this inserted text, the oldest steps are dropped first.
+// The document compacts its add buffer when the dropped steps referenced enough inserted text.
+type History struct {
+	undoEdits   []*historyEdit
+	redoEdits   []*historyEdit
+	memory      int64
+	memoryLimit int64
+	// dropped is the size of the inserted text referenced by the steps dropped since the last compaction
+	dropped int64
+}
+
+// NewHistory creates an empty history using DEFAULT_HISTORY_MEMORY_LIMIT.
+func NewHistory() *History {
+	return &History{memoryLimit: DEFAULT_HISTORY_MEMORY_LIMIT}
+}
+
+// SetMemoryLimit sets the maximum size of the inserted text referenced by the history,
+// dropping the oldest steps if needed. The add buffer is compacted at the next edit.
+func (h *History) SetMemoryLimit(limit int64) {
+	h.memoryLimit = limit
+	h.trim()
+}
+
+// GetMemoryLimit returns the maximum size of the inserted text referenced by the history.
+func (h *History) GetMemoryLimit() int64 {
+	return h.memoryLimit
+}
+
+// GetMemory returns the size of the inserted text referenced by the steps of undo and redo.
+func (h *History) GetMemory() int64 {
+	return h.memory
+}
+
+// CanUndo returns true if there is an edit to undo.
+func (h *History) CanUndo() bool {
+	return len(h.undoEdits) > 0
+}
+
+// CanRedo returns true if there is an edit to redo.
+func (h *History) CanRedo() bool {
+	return len(h.redoEdits) > 0
+}
+
+// Close ends the current step, the next edit won't be merged with the previous one.
+func (h *History) Close() {
+	if len(h.undoEdits) > 0 {
+		h.undoEdits[len(h.undoEdits)-1].closed = true
+	}
+}
+
+// Clear removes all the recorded edits.
+func (h *History) Clear() {
+	h.undoEdits = nil
+	h.redoEdits = nil
+	h.memory = 0
+	h.dropped = 0
+}
+
+// add records an edit, merging it with the previous one when typing or deleting consecutive characters
+func (h *History) add(deletion bool, globalIndex int64, pieces []piece, lineBreak bool) {
+	now := time.Now()
+	length := piecesLength(pieces)
+	memory := addedLength(pieces)
+	for _, e := range h.redoEdits {
+		h.drop(e)
+	}
+	h.redoEdits = nil
+
+	if n := len(h.undoEdits); n > 0 {
+		last := h.undoEdits[n-1]
+		if !last.closed && last.deletion == deletion && now.Sub(last.time) < COALESCE_DELAY {
+			merged := true
+			switch {
+			case !deletion && globalIndex == last.globalIndex+last.length:
+				// typing
+				last.pieces = append(last.pieces, pieces...)
+			case deletion && globalIndex+length == last.globalIndex:
+				// backspace
+				last.pieces = append(append([]piece{}, pieces...), last.pieces...)
+				last.globalIndex = globalIndex
+			case deletion && globalIndex == last.globalIndex:
+				// delete
+				last.pieces = append(last.pieces, pieces...)
+			default:
+				merged = false
+			}
+			if merged {
+				last.length += length
+				last.memory += memory
+				last.time = now
+				last.closed = lineBreak
+				h.memory += memory
+				h.trim()
+				return
+			}
+		}
+	}
+
+	h.undoEdits = append(h.undoEdits, &historyEdit{deletion: deletion, globalIndex: globalIndex, length: length, pieces: pieces, memory: memory, time: now, closed: lineBreak})
+	h.memory += memory
+	h.trim()
+}
+
+// trim drops the oldest edits until the memory limit is respected
+func (h *History) trim() {
+	for h.memory > h.memoryLimit && len(h.undoEdits) > 0 {
+		h.drop(h.undoEdits[0])
+		h.undoEdits[0] = nil
+		h.undoEdits = h.undoEdits[1:]
+	}
+	for h.memory > h.memoryLimit && len(h.redoEdits) > 0 {
+		h.drop(h.redoEdits[0])
+		h.redoEdits[0] = nil
+		h.redoEdits = h.redoEdits[1:]
+	}
+}
+
+// drop removes the memory of an edit which is dropped
+func (h *History) drop(e *historyEdit) {
+	h.memory -= e.memory
+	h.dropped += e.memory
+}
+
+// needsCompaction returns true if the dropped steps referenced enough of the add buffer, of the given size,
+// to compact it
+func (h *History) needsCompaction(addLength int) bool {
+	return h.dropped >= HISTORY_COMPACTION_SIZE && 2*h.dropped >= int64(addLength)
+}
+
+// getPieces returns the pieces of all the edits, see setPieces
+func (h *History) getPieces() [][]piece {
+	pieces := make([][]piece, 0, len(h.undoEdits)+len(h.redoEdits))
+	for _, e := range h.undoEdits {
+		pieces = append(pieces, e.pieces)
+	}
+	for _, e := range h.redoEdits {
+		pieces = append(pieces, e.pieces)
+	}
+	return pieces
+}
+
+// setPieces replaces the pieces of the edits after a compaction of the add buffer, in the order of getPieces
+func (h *History) setPieces(pieces [][]piece) {
+	for i, e := range h.undoEdits {
+		e.pieces = pieces[i]
+	}
+	for i, e := range h.redoEdits {
+		e.pieces = pieces[len(h.undoEdits)+i]
+	}
+	h.dropped = 0
+}
+
+func (h *History) popUndo() *historyEdit {
+	n := len(h.undoEdits)
+	if n == 0 {
+		return nil
+	}
+	e := h.undoEdits[n-1]
+	h.undoEdits = h.undoEdits[:n-1]
+	e.closed = true
+	h.redoEdits = append(h.redoEdits, e)
+	return e
+}
+
+func (h *History) popRedo() *historyEdit {
+	n := len(h.redoEdits)
+	if n == 0 {
+		return nil
+	}
+	e := h.redoEdits[n-1]
+	h.redoEdits = h.redoEdits[:n-1]
+	h.undoEdits = append(h.undoEdits, e)
+	return e
+}
+
+// String returns a string representation of the history.
+func (h *History) String() string {
+	return fmt.Sprintf("History [undo=%d, redo=%d, memory=%d, memoryLimit=%d]", len(h.undoEdits), len(h.redoEdits), h.memory, h.memoryLimit)
+}
+
+func piecesLength(pieces []piece) int64 {
+	length := int64(0)
+	for _, p := range pieces {
+		length += p.length
+	}
+	return length
+}
+
+// addedLength returns the length of the pieces of the add buffer
+func addedLength(pieces []piece) int64 {
+	length := int64(0)
+	for _, p := range pieces {
+		if p.source == SOURCE_ADD {
+			length += p.length
+		}
+	}
+	return length
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// checkText fails the test if the text of the document is not expected
+func checkText(t *testing.T, doc *Document, expected string) {
+	t.Helper()
+	if got := doc.GetText(0, doc.GetTotalLength()); got != expected {
+		t.Fatalf("text %q, expected %q", got, expected)
+	}
+}
+
+func TestHistoryMergesTyping(t *testing.T) {
+	doc := NewDocument()
+	doc.LoadFromString("hello world", 0)
+	for i, c := range "abc" {
+		doc.Insert(5+int64(i), string(c))
+	}
+	checkText(t, doc, "helloabc world")
+	if !doc.Undo() {
+		t.Fatal("nothing to undo")
+	}
+	checkText(t, doc, "hello world")
+	if doc.GetHistory().CanUndo() {
+		t.Fatal("typing not merged")
+	}
+	doc.Redo()
+	checkText(t, doc, "helloabc world")
+	// an insertion elsewhere is a new step
+	doc.Insert(0, "x")
+	doc.Undo()
+	checkText(t, doc, "helloabc world")
+}
+
+func TestHistoryMergesBackspaceAndDelete(t *testing.T) {
+	doc := NewDocument()
+	doc.LoadFromString("hello world", 0)
+	// backspace from the end of hello
+	doc.Delete(4, 5)
+	doc.Delete(3, 4)
+	doc.Delete(2, 3)
+	checkText(t, doc, "he world")
+	doc.GetHistory().Close()
+	// delete the letters after the space
+	doc.Delete(3, 4)
+	doc.Delete(3, 4)
+	checkText(t, doc, "he rld")
+
+	doc.Undo()
+	checkText(t, doc, "he world")
+	doc.Undo()
+	checkText(t, doc, "hello world")
+	if doc.GetHistory().CanUndo() {
+		t.Fatal("deletions not merged")
+	}
+	doc.Redo()
+	doc.Redo()
+	checkText(t, doc, "he rld")
+	// a deletion doesn't merge with an insertion
+	doc.Insert(6, "!")
+	doc.Delete(6, 7)
+	doc.Undo()
+	checkText(t, doc, "he rld!")
+}
+
+func TestHistoryLineBreakClosesStep(t *testing.T) {
+	doc := NewDocument()
+	doc.LoadFromString("", 0)
+	doc.Insert(0, "a")
+	doc.Insert(1, "b")
+	doc.Insert(2, "\n")
+	doc.Insert(3, "c")
+	doc.Insert(4, "\r\n")
+	checkText(t, doc, "ab\nc\r\n")
+	doc.Undo()
+	checkText(t, doc, "ab\n")
+	doc.Undo()
+	checkText(t, doc, "")
+	if doc.GetHistory().CanUndo() {
+		t.Fatal("too many steps")
+	}
+}
+
+func TestHistoryMemoryLimit(t *testing.T) {
+	doc := NewDocument()
+	doc.LoadFromString("xyz", 0)
+	history := doc.GetHistory()
+	for _, text := range []string{"aaaa", "bb", "c"} {
+		doc.Insert(doc.GetTotalLength(), text)
+		history.Close()
+	}
+	if history.GetMemory() != 7 {
+		t.Fatal(history)
+	}
+	// the oldest step is dropped
+	history.SetMemoryLimit(3)
+	if history.GetMemory() != 3 {
+		t.Fatal(history)
+	}
+	doc.Undo()
+	doc.Undo()
+	checkText(t, doc, "xyzaaaa")
+	if history.CanUndo() {
+		t.Fatal("dropped step still undoable")
+	}
+	// the redo steps are dropped after the undo steps
+	history.SetMemoryLimit(2)
+	if history.GetMemory() != 2 || !history.CanRedo() {
+		t.Fatal(history)
+	}
+	doc.Redo()
+	checkText(t, doc, "xyzaaaabb")
+	if history.CanRedo() {
+		t.Fatal("dropped step still redoable")
+	}
+	// the original text costs nothing
+	history.SetMemoryLimit(0)
+	doc.Delete(0, 3)
+	if history.GetMemory() != 0 || !history.CanUndo() {
+		t.Fatal(history)
+	}
+	doc.Undo()
+	checkText(t, doc, "xyzaaaabb")
+}
+
+func TestHistoryCompaction(t *testing.T) {
+	doc := NewDocument()
+	doc.LoadFromString("start\n", 4)
+	history := doc.GetHistory()
+	history.SetMemoryLimit(2 * 1024 * 1024)
+	r := rand.New(rand.NewSource(9))
+	block := make([]byte, 256*1024)
+	// states are the hashes of the text before each step
+	var states [][sha256.Size]byte
+	var snapshot *Snapshot
+	var snapshotText string
+	for i := 0; i < 120; i++ {
+		states = append(states, sha256.Sum256([]byte(doc.GetText(0, doc.GetTotalLength()))))
+		for j := range block {
+			block[j] = "ab\n"[r.Intn(3)]
+		}
+		n := doc.GetTotalLength()
+		if r.Intn(3) > 0 || n < 10 {
+			doc.Insert(r.Int63n(n+1), string(block))
+		} else {
+			start := r.Int63n(n)
+			doc.Delete(start, minInt64(n, start+r.Int63n(512*1024)))
+		}
+		history.Close()
+		if i == 30 {
+			snapshot = doc.Snapshot()
+			snapshotText = snapshot.GetText(0, snapshot.GetTotalLength())
+		}
+		if history.GetMemory() > history.GetMemoryLimit() {
+			t.Fatal(history)
+		}
+	}
+	// the add buffer holds the text of the document, of the history, and of the steps dropped since the last compaction
+	if int64(len(doc.table.add)) > doc.GetTotalLength()+history.GetMemory()+HISTORY_COMPACTION_SIZE+history.dropped {
+		t.Fatalf("add buffer not compacted: %d bytes, %v", len(doc.table.add), history)
+	}
+	if snapshot.GetText(0, snapshot.GetTotalLength()) != snapshotText {
+		t.Fatal("snapshot changed by the compaction")
+	}
+	final := doc.GetText(0, doc.GetTotalLength())
+	undone := 0
+	for doc.Undo() {
+		undone++
+		if sha256.Sum256([]byte(doc.GetText(0, doc.GetTotalLength()))) != states[len(states)-undone] {
+			t.Fatalf("undo %d: wrong text", undone)
+		}
+	}
+	if undone == 0 {
+		t.Fatal("nothing undone")
+	}
+	for doc.Redo() {
+	}
+	if doc.GetText(0, doc.GetTotalLength()) != final {
+		t.Fatal("redo: wrong text")
+	}
+	if strings.Count(final, "\n") != len(doc.GetLines())-1 {
+		t.Fatal("wrong lines after the redo")
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JOURNAL_MAGIC is the first line of a journal file
+const JOURNAL_MAGIC = "GigoNotePad journal 1"
+
+// Journal records the edits of a document which are not saved yet, in an append only file of the user cache directory,
+// to replay them on top of the saved file after a crash.
+// The journal file is only created at the first edit, with the hash of the file the edits apply to.
+// This hash is computed in background when the journal starts, the edits done before its end are kept in memory.
+// The records are not synced, they survive a crash of the editor but not always a crash of the OS.
+type Journal struct {
+	// path of the journal file
+	path string
+	// file is the edited file
+	file    string
+	charset string
+	// size and modTime of the file when the document was loaded, to detect a change before the first edit
+	size    int64
+	modTime time.Time
+	// mutex protects the fields below, which are also set by the goroutine hashing the file
+	mutex sync.Mutex
+	// hash is the hash of the file, computed in background when hashing is true
+	hash    string
+	hashErr error
+	hashing bool
+	// generation is incremented by each hash and by Close, to ignore the end of a previous hash
+	generation int
+	// pending are the records written during the hash, written in the journal file after it
+	pending []byte
+	// err is the error of writing the pending records, returned by the next write
+	err error
+	out *os.File
+}
+
+// JournalInfo describes the journal found for a file by FindJournal
+type JournalInfo struct {
+	Path    string
+	File    string
+	Charset string
+	// Matches is true if the file didn't change since the journal was created, the edits can then be recovered
+	Matches bool
+	ModTime time.Time
+}
+
+// GetJournalPath returns the path of the journal of the given file.
+func GetJournalPath(file string) (string, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(file))
+	return filepath.Join(dir, "GigoNotePad", "journals", hex.EncodeToString(hash[:16])+".journal"), nil
+}
+
+// newJournal creates the journal of the edits of a document loaded from file with the given charset,
+// the file must not have been modified since the load
+func newJournal(file string, charset string) (*Journal, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	path, err := GetJournalPath(file)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{path: path, file: file, charset: charset, size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// GetPath returns the path of the journal file.
+func (j *Journal) GetPath() string {
+	return j.path
+}
+
+// hashInBackground computes the hash of the file in another goroutine, the journal file is created with it
+func (j *Journal) hashInBackground() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.generation++
+	generation := j.generation
+	j.hash, j.hashErr, j.hashing = "", nil, true
+	go func() {
+		hash, err := hashFile(j.file)
+		j.mutex.Lock()
+		defer j.mutex.Unlock()
+		if j.generation != generation {
+			return
+		}
+		j.hash, j.hashErr, j.hashing = hash, err, false
+		if len(j.pending) > 0 {
+			j.err = j.create()
+			if j.err == nil {
+				_, j.err = j.out.Write(j.pending)
+			}
+			j.pending = nil
+		}
+	}()
+}
+
+// create creates the journal file, which must not exist, and writes its header with the hash of the file
+func (j *Journal) create() error {
+	if j.hashErr != nil {
+		return j.hashErr
+	}
+	info, err := os.Stat(j.file)
+	if err != nil {
+		return err
+	}
+	if info.Size() != j.size || !info.ModTime().Equal(j.modTime) {
+		return fmt.Errorf("%s was modified since it was loaded", j.file)
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\nfile=%s\ncharset=%s\nsha256=%s\n\n", JOURNAL_MAGIC, j.file, j.charset, j.hash)
+	if err != nil {
+		out.Close()
+		os.Remove(j.path)
+		return err
+	}
+	j.out = out
+	return nil
+}
+
+// write appends a record, creating the journal file if needed, or keeps it until the end of the hash
+func (j *Journal) write(record []byte) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.err != nil {
+		return j.err
+	}
+	if j.out == nil {
+		if j.hashing {
+			j.pending = append(j.pending, record...)
+			return nil
+		}
+		if err := j.create(); err != nil {
+			return err
+		}
+	}
+	// a single write, a crash can only truncate the last record
+	_, err := j.out.Write(record)
+	return err
+}
+
+// insert records the insertion of text at globalIndex
+func (j *Journal) insert(globalIndex int64, text []byte) error {
+	record := fmt.Appendf(nil, "I %d %d\n", globalIndex, len(text))
+	record = append(record, text...)
+	record = append(record, '\n')
+	return j.write(record)
+}
+
+// delete records the deletion of the text between start and end
+func (j *Journal) delete(start, end int64) error {
+	return j.write(fmt.Appendf(nil, "D %d %d\n", start, end))
+}
+
+// reset removes the journal file after the document has been saved in the file with the given charset,
+// the next edit creates it again
+func (j *Journal) reset(charset string) error {
+	if err := j.Remove(); err != nil {
+		return err
+	}
+	info, err := os.Stat(j.file)
+	if err != nil {
+		return err
+	}
+	j.charset = charset
+	j.size = info.Size()
+	j.modTime = info.ModTime()
+	j.hashInBackground()
+	return nil
+}
+
+// resume continues a journal file recorded for the same file, truncated to the given size
+func (j *Journal) resume(size int64) error {
+	out, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if err := out.Truncate(size); err != nil {
+		out.Close()
+		return err
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.out = out
+	return nil
+}
+
+// Close closes the journal file, keeping it. The records waiting for the end of the hash are lost.
+func (j *Journal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.generation++
+	j.hashing = false
+	j.pending = nil
+	if j.out == nil {
+		return nil
+	}
+	err := j.out.Close()
+	j.out = nil
+	return err
+}
+
+// Remove closes and removes the journal file.
+func (j *Journal) Remove() error {
+	err := j.Close()
+	if removeErr := os.Remove(j.path); removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) {
+		return removeErr
+	}
+	return err
+}
+
+// FindJournal returns the journal of the given file, or nil if there is none.
+func FindJournal(file string) (*JournalInfo, error) {
+	path, err := GetJournalPath(file)
+	if err != nil {
+		return nil, err
+	}
+	in, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	header, err := readJournalHeader(bufio.NewReader(in))
+	if err != nil {
+		return nil, fmt.Errorf("invalid journal %s: %w", path, err)
+	}
+	info := &JournalInfo{Path: path, File: header["file"], Charset: header["charset"]}
+	if stat, err := in.Stat(); err == nil {
+		info.ModTime = stat.ModTime()
+	}
+	hash, err := hashFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	info.Matches = err == nil && hash == header["sha256"]
+	return info, nil
+}
+
+// readJournalHeader reads the header of a journal file, ending with an empty line
+func readJournalHeader(in *bufio.Reader) (map[string]string, error) {
+	magic, err := in.ReadString('\n')
+	if err != nil || strings.TrimSuffix(magic, "\n") != JOURNAL_MAGIC {
+		return nil, errors.New("not a journal")
+	}
+	header := make(map[string]string)
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return nil, errors.New("truncated header")
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return header, nil
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid header line %q", line)
+		}
+		header[key] = value
+	}
+}
+
+// replayJournal applies to doc the edits recorded in the journal file,
+// and returns their number and the size of the journal up to the end of the last one.
+// A truncated last record, written during a crash, is ignored.
+func replayJournal(path string, doc *Document) (int, int64, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer in.Close()
+
+	reader := bufio.NewReader(in)
+	header, err := readJournalHeader(reader)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid journal %s: %w", path, err)
+	}
+	if header["charset"] != doc.GetCharset() {
+		return 0, 0, fmt.Errorf("journal %s was recorded in %s, the document is in %s", path, header["charset"], doc.GetCharset())
+	}
+	size, err := in.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, err
+	}
+	size -= int64(reader.Buffered())
+	stat, err := in.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	length := stat.Size()
+	count := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return count, size, nil
+		}
+		if err != nil {
+			return count, size, err
+		}
+		var op byte
+		var a, b int64
+		if _, err := fmt.Sscanf(line, "%c %d %d\n", &op, &a, &b); err != nil {
+			return count, size, fmt.Errorf("invalid journal record %q", line)
+		}
+		recordSize := int64(len(line))
+		switch op {
+		case 'I':
+			if b < 0 {
+				return count, size, fmt.Errorf("invalid journal record %q", line)
+			}
+			if b+1 > length-size-recordSize {
+				// truncated, or a length which cannot be read
+				return count, size, nil
+			}
+			text := make([]byte, b+1)
+			if _, err := io.ReadFull(reader, text); err != nil {
+				// truncated
+				return count, size, nil
+			}
+			if a < 0 || a > doc.GetTotalLength() || text[b] != '\n' {
+				return count, size, fmt.Errorf("invalid journal record %q", line)
+			}
+			doc.Insert(a, string(text[:b]))
+			recordSize += b + 1
+		case 'D':
+			if a < 0 || b < a || b > doc.GetTotalLength() {
+				return count, size, fmt.Errorf("invalid journal record %q", line)
+			}
+			doc.Delete(a, b)
+		default:
+			return count, size, fmt.Errorf("invalid journal record %q", line)
+		}
+		count++
+		size += recordSize
+	}
+}
+
+// hashFile returns the hexadecimal SHA-256 of the content of file
+func hashFile(file string) (string, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, in); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// String returns a string representation of the journal.
+func (j *Journal) String() string {
+	return fmt.Sprintf("Journal [path=%s, file=%s, charset=%s]", j.path, j.file, j.charset)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTempCacheDir makes the journals of the test written in a temporary user cache directory
+func useTempCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("LocalAppData", dir)
+}
+
+// waitJournal waits for the end of the hash of the file, after which the records are written in the journal file
+func waitJournal(j *Journal) {
+	for {
+		j.mutex.Lock()
+		hashing := j.hashing
+		j.mutex.Unlock()
+		if !hashing {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// loadFile loads file in a new document, failing the test on error
+func loadFile(t *testing.T, file string) *Document {
+	t.Helper()
+	doc := NewDocument()
+	if err := doc.LoadFrom(file, 0, CHARSET_AUTO, 0); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestJournalReplayTruncatedRecord(t *testing.T) {
+	useTempCacheDir(t)
+	file := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(file, []byte("hello\nworld\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := loadFile(t, file)
+	if err := doc.EnableJournal(file); err != nil {
+		t.Fatal(err)
+	}
+	doc.Insert(0, "abc")
+	doc.Insert(3, "\r\n")
+	doc.Delete(1, 2)
+	doc.Undo()
+	doc.Insert(doc.GetTotalLength(), "end")
+	waitJournal(doc.GetJournal())
+	expected := doc.GetText(0, doc.GetTotalLength())
+	recorded, err := os.Stat(doc.GetJournal().GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, torn := range []string{
+		// an insertion without all its text
+		"I 0 10\nabc",
+		// an insertion with a length larger than the journal
+		"I 0 1000000000000\nabc",
+		// a record without its end of line
+		"D 1",
+	} {
+		// the editor crashed while writing the last record
+		out, err := os.OpenFile(doc.GetJournal().GetPath(), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.WriteString(torn)
+		out.Close()
+
+		info, err := FindJournal(file)
+		if err != nil || info == nil || !info.Matches {
+			t.Fatal(torn, info, err)
+		}
+		recovered := loadFile(t, file)
+		if _, err := recovered.RecoverFoundJournal(file, info); err != nil {
+			t.Fatal(torn, err)
+		}
+		if got := recovered.GetText(0, recovered.GetTotalLength()); got != expected {
+			t.Fatalf("%q: recovered %q, expected %q", torn, got, expected)
+		}
+		// the recovered journal goes on after the last complete record
+		if stat, err := os.Stat(info.Path); err != nil || stat.Size() != recorded.Size() {
+			t.Fatal(torn, "torn record kept", err)
+		}
+		recovered.GetJournal().Close()
+	}
+
+	// the edits after the recovery are recorded
+	recovered := loadFile(t, file)
+	if _, err := recovered.RecoverJournal(file); err != nil {
+		t.Fatal(err)
+	}
+	recovered.Insert(0, "more")
+	expected = recovered.GetText(0, recovered.GetTotalLength())
+	again := loadFile(t, file)
+	if _, err := again.RecoverJournal(file); err != nil {
+		t.Fatal(err)
+	}
+	if got := again.GetText(0, again.GetTotalLength()); got != expected {
+		t.Fatalf("recovered %q, expected %q", got, expected)
+	}
+	again.GetJournal().Close()
+
+	// the save removes the journal
+	if err := recovered.Save(file, UTF8, AUTO); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := FindJournal(file); info != nil || err != nil {
+		t.Fatal("journal kept", err)
+	}
+}
+
+func TestJournalModifiedFile(t *testing.T) {
+	useTempCacheDir(t)
+	file := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(file, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := loadFile(t, file)
+	if err := doc.EnableJournal(file); err != nil {
+		t.Fatal(err)
+	}
+	doc.Insert(0, "x")
+	waitJournal(doc.GetJournal())
+	doc.GetJournal().Close()
+
+	// the edits don't apply to another content
+	if err := os.WriteFile(file, []byte("other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := FindJournal(file)
+	if err != nil || info == nil || info.Matches {
+		t.Fatal(info, err)
+	}
+	recovered := loadFile(t, file)
+	if _, err := recovered.RecoverFoundJournal(file, info); err == nil {
+		t.Fatal("edits recovered on a modified file")
+	}
+	if got := recovered.GetText(0, recovered.GetTotalLength()); got != "other\n" {
+		t.Fatalf("document modified: %q", got)
+	}
+	doc.DisableJournal()
+	if info, _ := FindJournal(file); info != nil {
+		t.Fatal("journal kept")
+	}
+}
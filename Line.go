@@ -5,6 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-text/typesetting/segmenter"
+	"golang.org/x/text/transform"
 )
 
 type Line struct {
@@ -14,6 +19,8 @@ type Line struct {
 	parts           []string
 	carriageReturn  bool
 	endsWithNewLine bool
+	// carriageReturnOnly is true if the line ends with a single '\r' (CR), carriageReturn is then also true
+	carriageReturnOnly bool
 }
 
 // NewLine is a constructor for the Line struct
@@ -23,10 +30,7 @@ func NewLine(parts []string, lineIndex int) *Line {
 		lineIndex: lineIndex,
 	}
 	copy(line.parts, parts)
-	for _, part := range line.parts {
-		line.length += int64(len(part))
-	}
-	line.computeLengthWithEOL()
+	line.computeLength()
 	return line
 }
 
@@ -52,16 +56,52 @@ func (l *Line) GetLengthWithEOL() int64 {
 	return l.lengthWithEOL
 }
 
+// computeLength computes the length from the parts
+func (l *Line) computeLength() {
+	l.length = 0
+	for _, part := range l.parts {
+		l.length += int64(len(part))
+	}
+	l.computeLengthWithEOL()
+}
+
 func (l *Line) computeLengthWithEOL() {
-	if l.endsWithNewLine {
-		if l.carriageReturn {
-			l.lengthWithEOL = l.length + 2
-		} else {
-			l.lengthWithEOL = l.length + 1
-		}
-	} else {
-		l.lengthWithEOL = l.length
+	l.lengthWithEOL = l.length + int64(len(l.getEOL()))
+}
+
+// getEOL returns the characters ending the line, empty if the line doesn't end with a new line
+func (l *Line) getEOL() string {
+	if !l.endsWithNewLine {
+		return ""
+	}
+	if l.carriageReturnOnly {
+		return "\r"
+	}
+	if l.carriageReturn {
+		return "\r\n"
 	}
+	return "\n"
+}
+
+// GetLineSeparator returns the end of line of the line (LF, CRLF or CR), AUTO if the line doesn't end with a new line
+func (l *Line) GetLineSeparator() LineSeparator {
+	switch l.getEOL() {
+	case "\n":
+		return LF
+	case "\r\n":
+		return CRLF
+	case "\r":
+		return CR
+	}
+	return AUTO
+}
+
+// SetLineSeparator sets the end of line of the line, AUTO removes the end of line
+func (l *Line) SetLineSeparator(separator LineSeparator) {
+	l.endsWithNewLine = separator != AUTO
+	l.carriageReturn = separator == CRLF || separator == CR
+	l.carriageReturnOnly = separator == CR
+	l.computeLengthWithEOL()
 }
 
 func (l *Line) GetLineIndex() int {
@@ -72,11 +112,12 @@ func (l *Line) SetLineIndex(index int) {
 	l.lineIndex = index
 }
 
+// CharAt returns the byte at the given byte index as a rune, see RuneAt for the code point
 func (l *Line) CharAt(index int64) (rune, error) {
 	if l.carriageReturn {
 		if index == l.length {
 			return '\r', nil
-		} else if index == l.length+1 {
+		} else if index == l.length+1 && !l.carriageReturnOnly {
 			return '\n', nil
 		}
 	} else {
@@ -98,6 +139,9 @@ func (l *Line) CharAt(index int64) (rune, error) {
 
 func (l *Line) SetUseCarriageReturn(b bool) {
 	l.carriageReturn = b
+	if !b {
+		l.carriageReturnOnly = false
+	}
 	l.computeLengthWithEOL()
 }
 
@@ -109,30 +153,28 @@ func (l *Line) GetText() string {
 	return l.GetString(0, l.length)
 }
 
+// GetString returns at most maxLength bytes of the line starting at the byte index, without end of line
 func (l *Line) GetString(index, maxLength int64) string {
-	var b strings.Builder
-	maxIndex := min(l.length, maxLength+index)
-	partStartIndex, partEndIndex := int64(0), int64(0)
-	partIndex := 0
-
-	size := len(l.parts)
-	for partIndex < size && partEndIndex <= index {
-		partStartIndex = partEndIndex
-		partEndIndex += int64(len(l.parts[partIndex]))
-		partIndex++
+	end := min(l.length, maxLength+index)
+	if index >= end {
+		return ""
 	}
-
-	for i := index; i < maxIndex; i++ {
-		if i >= partEndIndex {
-			if partIndex >= size {
-				break
+	var b strings.Builder
+	partStart := int64(0)
+	for _, part := range l.parts {
+		partEnd := partStart + int64(len(part))
+		if partEnd > index {
+			text := part[maxInt64(0, index-partStart):minInt64(int64(len(part)), end-partStart)]
+			if partStart <= index && partEnd >= end {
+				// inside a single part, no copy
+				return text
 			}
-			partStartIndex = partEndIndex
-			partEndIndex += int64(len(l.parts[partIndex]))
-			partIndex++
+			b.WriteString(text)
 		}
-		indexInPart := int(i - partStartIndex)
-		b.WriteByte(l.parts[partIndex-1][indexInPart])
+		if partEnd >= end {
+			break
+		}
+		partStart = partEnd
 	}
 	return b.String()
 }
@@ -146,11 +188,7 @@ func (l *Line) String() string {
 	}
 	end := "EOF"
 	if l.endsWithNewLine {
-		if l.carriageReturn {
-			end = "CRLF"
-		} else {
-			end = "LF"
-		}
+		end = l.GetLineSeparator().String()
 	}
 	return fmt.Sprintf("Line [index=%d length=%d, index=%d(%d parts) %s ] %s",
 		l.lineIndex, l.length, l.lineIndex, len(l.parts), end, text)
@@ -169,7 +207,7 @@ func (l *Line) Append(b *strings.Builder, start int64, end int64) int64 {
 
 	b.WriteString(l.GetString(start, end-start))
 	if end > l.length {
-		b.WriteByte('\n')
+		b.WriteString(l.getEOL()[maxInt64(0, start-l.length) : end-l.length])
 	}
 	return end - start
 }
@@ -218,8 +256,8 @@ func (l *Line) Delete(start, end int64) {
 		panic(fmt.Sprintf("Invalid start or end index (%d, %d). Line length: %d (%d with EOL)", start, end, l.length, l.GetLengthWithEOL()))
 	}
 
-	if (end == l.length+1 || end == l.length+2) && l.endsWithNewLine {
-		l.endsWithNewLine = false
+	if end > l.length && l.endsWithNewLine {
+		l.deleteEOL(maxInt64(0, start-l.length), end-l.length)
 	}
 
 	currentIndex := int64(0)
@@ -241,6 +279,19 @@ func (l *Line) Delete(start, end int64) {
 	}
 }
 
+// deleteEOL removes the characters between start and end of the end of line
+func (l *Line) deleteEOL(start, end int64) {
+	eol := l.getEOL()
+	switch eol[:start] + eol[end:] {
+	case "":
+		l.SetLineSeparator(AUTO)
+	case "\n":
+		l.SetLineSeparator(LF)
+	case "\r":
+		l.SetLineSeparator(CR)
+	}
+}
+
 func (l *Line) IndexOf(text string, fromIndex int64) int64 {
 	stop := len(l.parts)
 	textLength := int64(len(text))
@@ -280,7 +331,31 @@ func (l *Line) Find(text string, globalIndexOfLine int64, result *[]int64) {
 	}
 }
 
-func (l *Line) WriteTo(bOut *bufio.Writer, charset string, separator LineSeparator) error {
+// WriteTo writes the line, converted to the given charset, and its end of line, without byte order mark
+func (l *Line) WriteTo(out *bufio.Writer, charset string, separator LineSeparator) error {
+	enc, err := GetEncoding(charset)
+	if err != nil {
+		return err
+	}
+	if enc == nil {
+		return l.writeUTF8To(out, separator)
+	}
+	// the encoder is given the whole line as a rune may be split between two parts
+	encoder := transform.NewWriter(out, enc.NewEncoder())
+	bOut := bufio.NewWriter(encoder)
+	if err := l.writeUTF8To(bOut, separator); err != nil {
+		return err
+	}
+	if err := bOut.Flush(); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("cannot encode to %s: %w", charset, err)
+	}
+	return nil
+}
+
+func (l *Line) writeUTF8To(bOut *bufio.Writer, separator LineSeparator) error {
 	stop := len(l.parts)
 	for i := 0; i < stop; i++ {
 		if _, err := bOut.WriteString(l.parts[i]); err != nil {
@@ -290,12 +365,8 @@ func (l *Line) WriteTo(bOut *bufio.Writer, charset string, separator LineSeparat
 	if l.endsWithNewLine {
 		switch separator {
 		case AUTO:
-			if l.carriageReturn {
-				if _, err := bOut.WriteRune('\r'); err != nil {
-					return err
-				}
-			}
-			if _, err := bOut.WriteRune('\n'); err != nil {
+			// the original end of line
+			if _, err := bOut.WriteString(l.getEOL()); err != nil {
 				return err
 			}
 		case LF:
@@ -309,11 +380,252 @@ func (l *Line) WriteTo(bOut *bufio.Writer, charset string, separator LineSeparat
 			if _, err := bOut.WriteRune('\n'); err != nil {
 				return err
 			}
+		case CR:
+			if _, err := bOut.WriteRune('\r'); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// RuneAt returns the code point starting at the given byte index and its size in bytes.
+// An invalid byte is returned as utf8.RuneError of size 1.
+func (l *Line) RuneAt(index int64) (rune, int) {
+	if index < 0 || index >= l.length {
+		panic(fmt.Sprintf("invalid index %d, length is %d", index, l.length))
+	}
+	return utf8.DecodeRuneInString(l.GetString(index, utf8.UTFMax))
+}
+
+// forEachRune calls f with the byte index, the code point and its size of each code point from the byte index from,
+// until f returns false. A code point cut between two parts is decoded whole.
+func (l *Line) forEachRune(from int64, f func(index int64, r rune, size int) bool) {
+	index := from
+	partStart := int64(0)
+	for _, part := range l.parts {
+		partEnd := partStart + int64(len(part))
+		for index < partEnd {
+			text := part[index-partStart:]
+			if !utf8.FullRuneInString(text) && partEnd < l.length {
+				text = l.GetString(index, utf8.UTFMax)
+			}
+			r, size := utf8.DecodeRuneInString(text)
+			if !f(index, r, size) {
+				return
+			}
+			index += int64(size)
+		}
+		partStart = partEnd
+	}
+}
+
+// RuneCount returns the number of code points of the line, without end of line.
+func (l *Line) RuneCount() int64 {
+	count := int64(0)
+	l.forEachRune(0, func(index int64, r rune, size int) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// GetRuneIndex returns the number of code points before the given byte index.
+func (l *Line) GetRuneIndex(index int64) int64 {
+	if index < 0 || index > l.length {
+		panic(fmt.Sprintf("invalid index %d, length is %d", index, l.length))
+	}
+	count := int64(0)
+	l.forEachRune(0, func(i int64, r rune, size int) bool {
+		if i >= index {
+			return false
+		}
+		count++
+		return true
+	})
+	return count
+}
+
+// GetByteIndex returns the byte index of the code point at the given code point index,
+// the length of the line for the code point count.
+func (l *Line) GetByteIndex(runeIndex int64) int64 {
+	if runeIndex < 0 {
+		panic(fmt.Sprintf("invalid code point index %d", runeIndex))
+	}
+	result := int64(-1)
+	count := int64(0)
+	l.forEachRune(0, func(i int64, r rune, size int) bool {
+		if count == runeIndex {
+			result = i
+			return false
+		}
+		count++
+		return true
+	})
+	if result < 0 {
+		if count != runeIndex {
+			panic(fmt.Sprintf("invalid code point index %d, code point count is %d", runeIndex, count))
+		}
+		return l.length
+	}
+	return result
+}
+
+// NextRuneBoundary returns the byte index following the code point at the given byte index.
+func (l *Line) NextRuneBoundary(index int64) int64 {
+	if index >= l.length {
+		return l.length
+	}
+	_, size := l.RuneAt(index)
+	return index + int64(size)
+}
+
+// PreviousRuneBoundary returns the byte index of the code point preceding the given byte index.
+func (l *Line) PreviousRuneBoundary(index int64) int64 {
+	if index <= 0 {
+		return 0
+	}
+	start := maxInt64(0, index-utf8.UTFMax)
+	_, size := utf8.DecodeLastRuneInString(l.GetString(start, index-start))
+	return index - int64(size)
+}
+
+// isGraphemeExtension returns true if r continues the grapheme cluster ending the text before: a combining mark,
+// a joiner, a variation selector, an emoji modifier or tag, or the second regional indicator of a flag.
+// It is an approximation of the rules used by NextGraphemeBoundary, enough to choose where to cut the parts of a line.
+func isGraphemeExtension(before string, r rune) bool {
+	previous, _ := utf8.DecodeLastRuneInString(before)
+	switch {
+	case previous == ZERO_WIDTH_JOINER, r == ZERO_WIDTH_JOINER:
+		return true
+	case unicode.Is(unicode.M, r), unicode.Is(unicode.Variation_Selector, r):
+		return true
+	case r >= 0x1F3FB && r <= 0x1F3FF:
+		// emoji modifiers (skin tones)
+		return true
+	case r >= 0xE0020 && r <= 0xE007F:
+		// tags
+		return true
+	case isRegionalIndicator(r):
+		// a flag is a pair of regional indicators
+		count := 0
+		for len(before) > 0 {
+			p, size := utf8.DecodeLastRuneInString(before)
+			if !isRegionalIndicator(p) {
+				break
+			}
+			count++
+			before = before[:len(before)-size]
+		}
+		return count%2 == 1
+	}
+	return false
+}
+
+// ZERO_WIDTH_JOINER joins emojis into a single grapheme cluster
+const ZERO_WIDTH_JOINER = '\u200D'
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// checkPartBoundaries returns an error if a code point is cut between two parts
+func (l *Line) checkPartBoundaries() error {
+	for i := 1; i < len(l.parts); i++ {
+		previous := l.parts[i-1]
+		tail := previous[maxInt64(0, int64(len(previous))-utf8.UTFMax+1):]
+		next := l.parts[i]
+		joined := tail + next[:minInt64(int64(len(next)), utf8.UTFMax)]
+		for k := 0; k < len(tail); k++ {
+			if !utf8.RuneStart(tail[k]) {
+				continue
+			}
+			r, size := utf8.DecodeRuneInString(joined[k:])
+			if (r != utf8.RuneError || size > 1) && k+size > len(tail) {
+				return fmt.Errorf("the code point %U is cut between the parts %d and %d of line %d", r, i-1, i, l.lineIndex)
+			}
+		}
+	}
+	return nil
+}
+
+// GRAPHEME_WINDOW is the number of bytes segmented before and after an index to find the grapheme cluster boundaries around it.
+// The boundaries are exact unless a grapheme cluster is longer than the window.
+const GRAPHEME_WINDOW = 256
+
+// NextGraphemeBoundary returns the byte index following the grapheme cluster (the character perceived by the user,
+// like a letter and its accents or an emoji sequence) at the given byte index.
+func (l *Line) NextGraphemeBoundary(index int64) int64 {
+	if index >= l.length {
+		return l.length
+	}
+	boundaries := l.graphemeBoundaries(index-GRAPHEME_WINDOW, index+GRAPHEME_WINDOW)
+	for _, boundary := range boundaries {
+		if boundary > index {
+			return boundary
+		}
+	}
+	return l.length
+}
+
+// PreviousGraphemeBoundary returns the byte index of the grapheme cluster preceding the given byte index.
+func (l *Line) PreviousGraphemeBoundary(index int64) int64 {
+	if index <= 0 {
+		return 0
+	}
+	boundaries := l.graphemeBoundaries(index-GRAPHEME_WINDOW, index+GRAPHEME_WINDOW)
+	for i := len(boundaries) - 1; i >= 0; i-- {
+		if boundaries[i] < index {
+			return boundaries[i]
+		}
+	}
+	return 0
+}
+
+// graphemeBoundaries returns the byte indexes of the grapheme cluster boundaries between start and end,
+// both moved to the start of a code point and clamped to the line
+func (l *Line) graphemeBoundaries(start, end int64) []int64 {
+	start = l.alignToRune(maxInt64(0, start))
+	end = l.alignToRune(minInt64(l.length, end))
+	text := l.GetString(start, end-start)
+	runes := make([]rune, 0, len(text))
+	offsets := make([]int64, 0, len(text)+1)
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		runes = append(runes, r)
+		offsets = append(offsets, start+int64(i))
+		i += size
+	}
+	offsets = append(offsets, end)
+
+	var seg segmenter.Segmenter
+	seg.Init(runes)
+	boundaries := make([]int64, 0, len(runes)+1)
+	iterator := seg.GraphemeIterator()
+	for iterator.Next() {
+		boundaries = append(boundaries, offsets[iterator.Grapheme().Offset])
+	}
+	return append(boundaries, end)
+}
+
+// alignToRune returns the start of the code point containing the byte index
+func (l *Line) alignToRune(index int64) int64 {
+	if index >= l.length {
+		return l.length
+	}
+	for i := index; i >= 0 && i > index-utf8.UTFMax; i-- {
+		text := l.GetString(i, utf8.UTFMax)
+		if utf8.RuneStart(text[0]) {
+			if _, size := utf8.DecodeRuneInString(text); i+int64(size) > index {
+				return i
+			}
+			// an invalid byte
+			return index
+		}
+	}
+	return index
+}
+
 // Helper functions
 func min(a, b int64) int64 {
 	if a < b {
@@ -335,6 +647,13 @@ func minInt64(a, b int64) int64 {
 	return b
 }
 
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 // Enum equivalent for LineSeparator
 type LineSeparator int
 
@@ -342,4 +661,17 @@ const (
 	AUTO LineSeparator = iota
 	LF
 	CRLF
+	CR
 )
+
+func (s LineSeparator) String() string {
+	switch s {
+	case LF:
+		return "LF"
+	case CRLF:
+		return "CRLF"
+	case CR:
+		return "CR"
+	}
+	return "AUTO"
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+)
+
+// LineEndings contains the number of lines ending with each kind of end of line.
+type LineEndings struct {
+	LF   int
+	CRLF int
+	CR   int
+}
+
+// IsMixed returns true if more than one kind of end of line is used.
+func (e LineEndings) IsMixed() bool {
+	kinds := 0
+	for _, count := range []int{e.LF, e.CRLF, e.CR} {
+		if count > 0 {
+			kinds++
+		}
+	}
+	return kinds > 1
+}
+
+// GetMostUsed returns the most used end of line, LF if there is none.
+func (e LineEndings) GetMostUsed() LineSeparator {
+	if e.CRLF > e.LF && e.CRLF >= e.CR {
+		return CRLF
+	}
+	if e.CR > e.LF && e.CR > e.CRLF {
+		return CR
+	}
+	return LF
+}
+
+// String returns a string representation of the line endings.
+func (e LineEndings) String() string {
+	return fmt.Sprintf("LineEndings [LF=%d, CRLF=%d, CR=%d]", e.LF, e.CRLF, e.CR)
+}
+
+// CountLineEndings returns how many lines end with LF, CRLF and CR, without creating the lines.
+func (doc *Document) CountLineEndings() LineEndings {
+	var endings LineEndings
+	doc.table.scanLineEnds(func(next int64, separator LineSeparator) {
+		switch separator {
+		case LF:
+			endings.LF++
+		case CRLF:
+			endings.CRLF++
+		case CR:
+			endings.CR++
+		}
+	})
+	return endings
+}
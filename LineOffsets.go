@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// LINE_OFFSETS_BLOCK_SIZE is the number of lines of a block of LineOffsets
+const LINE_OFFSETS_BLOCK_SIZE = 1024
+
+// LineOffsets is a chunked Fenwick tree of the lengths (with end of line) of the lines of a document.
+// The lines are grouped in blocks of about LINE_OFFSETS_BLOCK_SIZE lines, each block is a Fenwick tree of the lengths
+// of its lines, and two Fenwick trees index the lengths and the line counts of the blocks.
+// It converts global indexes to line indexes and back in O(log n), and updates the length of a line in O(log n).
+// Adding or removing lines rebuilds only the blocks of these lines, and the index of the blocks when their number changes,
+// in O(LINE_OFFSETS_BLOCK_SIZE + n / LINE_OFFSETS_BLOCK_SIZE).
+type LineOffsets struct {
+	blocks []fenwickTree
+	// lengths is the tree of the sums of the lengths of the lines of each block
+	lengths fenwickTree
+	// counts is the tree of the numbers of lines of each block
+	counts    fenwickTree
+	lineCount int
+}
+
+// NewLineOffsets creates the tree from the lengths of the lines, in O(n).
+func NewLineOffsets(lengths []int64) *LineOffsets {
+	o := &LineOffsets{}
+	o.blocks = splitOffsetBlocks(lengths)
+	o.lineCount = len(lengths)
+	o.indexBlocks()
+	return o
+}
+
+// splitOffsetBlocks cuts the lengths in blocks of the same size, at most LINE_OFFSETS_BLOCK_SIZE lines
+func splitOffsetBlocks(lengths []int64) []fenwickTree {
+	if len(lengths) == 0 {
+		return nil
+	}
+	count := (len(lengths) + LINE_OFFSETS_BLOCK_SIZE - 1) / LINE_OFFSETS_BLOCK_SIZE
+	blocks := make([]fenwickTree, count)
+	start := 0
+	for i := range blocks {
+		end := len(lengths) * (i + 1) / count
+		blocks[i] = newFenwickTree(lengths[start:end])
+		start = end
+	}
+	return blocks
+}
+
+// indexBlocks creates again the trees of the lengths and of the line counts of the blocks
+func (o *LineOffsets) indexBlocks() {
+	lengths := make([]int64, len(o.blocks))
+	counts := make([]int64, len(o.blocks))
+	for i, block := range o.blocks {
+		lengths[i] = block.total()
+		counts[i] = int64(block.size())
+	}
+	o.lengths = newFenwickTree(lengths)
+	o.counts = newFenwickTree(counts)
+}
+
+// GetLineCount returns the number of lines.
+func (o *LineOffsets) GetLineCount() int {
+	return o.lineCount
+}
+
+// locate returns the block of the line, and the index of the line in this block
+func (o *LineOffsets) locate(lineIndex int) (int, int) {
+	block, local := o.counts.find(int64(lineIndex))
+	return block, int(local)
+}
+
+// GetOffset returns the global index of the first character of the line.
+// lineIndex can be the line count, the total length is then returned.
+func (o *LineOffsets) GetOffset(lineIndex int) int64 {
+	if lineIndex < 0 || lineIndex > o.lineCount {
+		panic(fmt.Sprintf("invalid line index %d, line count is %d", lineIndex, o.lineCount))
+	}
+	if lineIndex == o.lineCount {
+		return o.GetTotalLength()
+	}
+	block, local := o.locate(lineIndex)
+	return o.lengths.prefix(block) + o.blocks[block].prefix(local)
+}
+
+// GetTotalLength returns the sum of the lengths of all the lines.
+func (o *LineOffsets) GetTotalLength() int64 {
+	return o.lengths.total()
+}
+
+// GetLength returns the length, with end of line, of the line.
+func (o *LineOffsets) GetLength(lineIndex int) int64 {
+	if lineIndex < 0 || lineIndex >= o.lineCount {
+		panic(fmt.Sprintf("invalid line index %d, line count is %d", lineIndex, o.lineCount))
+	}
+	block, local := o.locate(lineIndex)
+	return o.blocks[block].get(local)
+}
+
+// Add adds delta to the length of the line.
+func (o *LineOffsets) Add(lineIndex int, delta int64) {
+	if lineIndex < 0 || lineIndex >= o.lineCount {
+		panic(fmt.Sprintf("invalid line index %d, line count is %d", lineIndex, o.lineCount))
+	}
+	block, local := o.locate(lineIndex)
+	o.blocks[block].add(local, delta)
+	o.lengths.add(block, delta)
+}
+
+// SetLength sets the length, with end of line, of the line.
+func (o *LineOffsets) SetLength(lineIndex int, length int64) {
+	o.Add(lineIndex, length-o.GetLength(lineIndex))
+}
+
+// FindLine returns the index of the line containing the global index.
+// The global index can be the total length, the last line is then returned.
+func (o *LineOffsets) FindLine(globalIndex int64) int {
+	if globalIndex < 0 || o.lineCount == 0 {
+		panic(fmt.Sprintf("invalid global index %d, line count is %d", globalIndex, o.lineCount))
+	}
+	block, remaining := o.lengths.find(globalIndex)
+	if block >= len(o.blocks) {
+		if remaining > 0 {
+			panic(fmt.Sprintf("invalid global index %d, length is %d", globalIndex, o.GetTotalLength()))
+		}
+		return o.lineCount - 1
+	}
+	local, _ := o.blocks[block].find(remaining)
+	return int(o.counts.prefix(block)) + local
+}
+
+// getLengths returns the lengths of all the lines, in O(n)
+func (o *LineOffsets) getLengths() []int64 {
+	lengths := make([]int64, 0, o.lineCount)
+	for _, block := range o.blocks {
+		lengths = append(lengths, block.values()...)
+	}
+	return lengths
+}
+
+// Replace replaces count lines starting at first by lines of the given lengths.
+// Only the blocks of the replaced lines are created again, with the next block if they become too small.
+func (o *LineOffsets) Replace(first int, count int, lengths []int64) {
+	if first < 0 || count < 0 || first+count > o.lineCount {
+		panic(fmt.Sprintf("invalid lines %d to %d, line count is %d", first, first+count, o.lineCount))
+	}
+	if len(o.blocks) == 0 {
+		*o = *NewLineOffsets(lengths)
+		return
+	}
+	// the blocks firstBlock to lastBlock (included) contain the replaced lines, or the insertion point
+	firstBlock := len(o.blocks) - 1
+	if first < o.lineCount {
+		firstBlock, _ = o.locate(first)
+	}
+	lastBlock := firstBlock
+	if count > 0 {
+		lastBlock, _ = o.locate(first + count - 1)
+	}
+	var old []int64
+	for i := firstBlock; i <= lastBlock; i++ {
+		old = append(old, o.blocks[i].values()...)
+	}
+	local := first - int(o.counts.prefix(firstBlock))
+	values := make([]int64, 0, len(old)-count+len(lengths))
+	values = append(values, old[:local]...)
+	values = append(values, lengths...)
+	values = append(values, old[local+count:]...)
+	if len(values) < LINE_OFFSETS_BLOCK_SIZE/2 && lastBlock+1 < len(o.blocks) {
+		// merge the small block with the next one
+		lastBlock++
+		values = append(values, o.blocks[lastBlock].values()...)
+	}
+	blocks := splitOffsetBlocks(values)
+	o.lineCount += len(lengths) - count
+	if len(blocks) == lastBlock-firstBlock+1 {
+		// same number of blocks, only their lengths and counts change
+		for i, block := range blocks {
+			o.lengths.add(firstBlock+i, block.total()-o.blocks[firstBlock+i].total())
+			o.counts.add(firstBlock+i, int64(block.size()-o.blocks[firstBlock+i].size()))
+			o.blocks[firstBlock+i] = block
+		}
+		return
+	}
+	o.blocks = append(o.blocks[:firstBlock:firstBlock], append(blocks, o.blocks[lastBlock+1:]...)...)
+	o.indexBlocks()
+}
+
+// String returns a string representation of the offsets.
+func (o *LineOffsets) String() string {
+	return fmt.Sprintf("LineOffsets [lines=%d, blocks=%d, length=%d]", o.lineCount, len(o.blocks), o.GetTotalLength())
+}
+
+// fenwickTree is a Fenwick tree of values: tree[i] is the sum of the values i-lowbit(i) to i-1, tree[0] is unused
+type fenwickTree []int64
+
+// newFenwickTree creates the tree of the values, in O(n)
+func newFenwickTree(values []int64) fenwickTree {
+	tree := make(fenwickTree, len(values)+1)
+	copy(tree[1:], values)
+	for i := 1; i < len(tree); i++ {
+		parent := i + (i & -i)
+		if parent < len(tree) {
+			tree[parent] += tree[i]
+		}
+	}
+	return tree
+}
+
+// size returns the number of values
+func (t fenwickTree) size() int {
+	return len(t) - 1
+}
+
+// prefix returns the sum of the values before index
+func (t fenwickTree) prefix(index int) int64 {
+	sum := int64(0)
+	for i := index; i > 0; i -= i & -i {
+		sum += t[i]
+	}
+	return sum
+}
+
+// total returns the sum of all the values
+func (t fenwickTree) total() int64 {
+	return t.prefix(t.size())
+}
+
+// get returns the value at index
+func (t fenwickTree) get(index int) int64 {
+	return t.prefix(index+1) - t.prefix(index)
+}
+
+// add adds delta to the value at index
+func (t fenwickTree) add(index int, delta int64) {
+	for i := index + 1; i < len(t); i += i & -i {
+		t[i] += delta
+	}
+}
+
+// find returns the number of values whose prefix sum including them is lower or equal to sum,
+// which is the index of the value containing sum, and the rest of sum after these values
+func (t fenwickTree) find(sum int64) (int, int64) {
+	position := 0
+	if t.size() == 0 {
+		return 0, sum
+	}
+	for step := 1 << (bits.Len(uint(t.size())) - 1); step > 0; step >>= 1 {
+		next := position + step
+		if next < len(t) && t[next] <= sum {
+			position = next
+			sum -= t[next]
+		}
+	}
+	return position, sum
+}
+
+// values returns the values of the tree, in O(n)
+func (t fenwickTree) values() []int64 {
+	values := make([]int64, len(t))
+	copy(values, t)
+	// undo newFenwickTree, from the end
+	for i := len(values) - 1; i > 0; i-- {
+		parent := i + (i & -i)
+		if parent < len(values) {
+			values[parent] -= values[i]
+		}
+	}
+	return values[1:]
+}
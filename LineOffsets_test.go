@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// checkOffsets compares the offsets with the lengths of the lines
+func checkOffsets(t *testing.T, offsets *LineOffsets, lengths []int64, step int) {
+	t.Helper()
+	if offsets.GetLineCount() != len(lengths) {
+		t.Fatalf("step %d: %d lines, expected %d", step, offsets.GetLineCount(), len(lengths))
+	}
+	offset := int64(0)
+	for i, length := range lengths {
+		if offsets.GetOffset(i) != offset || offsets.GetLength(i) != length {
+			t.Fatalf("step %d: line %d at %d length %d, expected %d length %d", step, i, offsets.GetOffset(i), offsets.GetLength(i), offset, length)
+		}
+		if offsets.FindLine(offset) != i || offsets.FindLine(offset+length-1) != i {
+			t.Fatalf("step %d: line %d not found", step, i)
+		}
+		offset += length
+	}
+	if offsets.GetTotalLength() != offset {
+		t.Fatalf("step %d: total length %d, expected %d", step, offsets.GetTotalLength(), offset)
+	}
+	for _, block := range offsets.blocks {
+		if block.size() == 0 || block.size() > LINE_OFFSETS_BLOCK_SIZE {
+			t.Fatalf("step %d: block of %d lines", step, block.size())
+		}
+	}
+}
+
+func TestLineOffsetsReplace(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	var lengths []int64
+	offsets := NewLineOffsets(nil)
+	for step := 0; step < 2000; step++ {
+		from := r.Intn(len(lengths) + 1)
+		count := 0
+		if from < len(lengths) {
+			count = r.Intn(minInt(len(lengths)-from, 1+r.Intn(3000)) + 1)
+		}
+		inserted := make([]int64, r.Intn(3))
+		if r.Intn(3) == 0 {
+			// more lines than a block
+			inserted = make([]int64, r.Intn(2000))
+		}
+		for i := range inserted {
+			inserted[i] = int64(r.Intn(9) + 1)
+		}
+		offsets.Replace(from, count, inserted)
+		lengths = append(append(append([]int64{}, lengths[:from]...), inserted...), lengths[from+count:]...)
+		if len(lengths) > 0 && r.Intn(2) == 0 {
+			i := r.Intn(len(lengths))
+			offsets.Add(i, 2)
+			lengths[i] += 2
+		}
+		if step%100 == 0 {
+			checkOffsets(t, offsets, lengths, step)
+		}
+	}
+	checkOffsets(t, offsets, lengths, -1)
+}
+
+func TestLineOffsetsAfterEdits(t *testing.T) {
+	doc := NewDocument()
+	doc.LoadFromString("ab\r\ncd\ref\n", 100)
+	if index := doc.GetIndex(5); index.lineIndex != 1 || index.charIndexInLine != 1 {
+		t.Fatal(index)
+	}
+	if doc.GetGlobalIndex(2, 1) != 8 || doc.GetIndex(10).lineIndex != 3 {
+		t.Fatal(doc.GetGlobalIndex(2, 1), doc.GetIndex(10))
+	}
+	doc.Insert(0, "x\ny")
+	if index := doc.GetIndex(8); index.lineIndex != 2 || index.charIndexInLine != 1 {
+		t.Fatal(index)
+	}
+	doc.Delete(1, 7)
+	if text := doc.GetText(0, doc.GetTotalLength()); text != "xcd\ref\n" {
+		t.Fatalf("%q", text)
+	}
+	if index := doc.GetIndex(4); index.lineIndex != 1 || index.charIndexInLine != 0 || doc.GetLineCount() != 3 {
+		t.Fatal(index, doc.GetLineCount())
+	}
+	validate(t, doc, "delete")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/text/transform"
+)
+
+// LoadProgress describes how far the loading of a document is.
+type LoadProgress struct {
+	BytesRead  int64
+	TotalBytes int64 // -1 if unknown
+	LinesFound int
+}
+
+// Fraction returns the progress between 0 and 1, or 0 if the total is unknown.
+func (p LoadProgress) Fraction() float64 {
+	if p.TotalBytes <= 0 {
+		return 0
+	}
+	return float64(p.BytesRead) / float64(p.TotalBytes)
+}
+
+// String returns a string representation of the progress.
+func (p LoadProgress) String() string {
+	return fmt.Sprintf("LoadProgress [bytesRead=%d, totalBytes=%d, linesFound=%d]", p.BytesRead, p.TotalBytes, p.LinesFound)
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r     io.Reader
+	count int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// LoadFromReader reads the document from r, by blocks of CHUNK_SIZE bytes, and decodes it from charset.
+// If the content starts with a byte order mark, the charset is given by the byte order mark.
+// With CHARSET_AUTO, the charset is detected from the first PRELOAD_SIZE bytes.
+// The blocks are split into lines in parallel while reading.
+// totalBytes is the expected size of the content, -1 if unknown.
+// progress, if not nil, is called after each block.
+// If ctx is cancelled, the loading stops, the document is left unchanged and ctx.Err() is returned.
+func (doc *Document) LoadFromReader(ctx context.Context, r io.Reader, charset string, totalBytes int64, maxPartSize int, progress func(LoadProgress)) error {
+	startTime := time.Now()
+	if err := checkCharset(charset); err != nil {
+		return err
+	}
+	counter := &countingReader{r: r}
+	buffered := bufio.NewReaderSize(counter, PRELOAD_SIZE)
+	if IsAutoCharset(charset) {
+		head, _ := buffered.Peek(PRELOAD_SIZE)
+		charset = DetectCharset(head).Charset
+	}
+	head, _ := buffered.Peek(len(BOM_UTF8))
+	bomCharset, bomSize := detectBOM(head)
+	if bomSize > 0 {
+		charset = bomCharset
+		buffered.Discard(bomSize)
+	}
+	enc, err := GetEncoding(charset)
+	if err != nil {
+		return err
+	}
+	var in io.Reader = buffered
+	if enc != nil {
+		in = transform.NewReader(buffered, enc.NewDecoder())
+	}
+
+	capacity := int64(CHUNK_SIZE)
+	if totalBytes > 0 {
+		capacity = totalBytes + 1
+	}
+	data := make([]byte, 0, capacity)
+	// the bytes up to split are given to the splitter, by blocks ending with a line
+	splitter := newParallelSplitter()
+	split := 0
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if len(data) == cap(data) {
+			grown := make([]byte, len(data), 2*cap(data)+CHUNK_SIZE)
+			copy(grown, data)
+			data = grown
+		}
+		chunk := data[len(data):minInt64(int64(cap(data)), int64(len(data)+CHUNK_SIZE))]
+		n, err := io.ReadFull(in, chunk)
+		data = data[:len(data)+n]
+		// only the new bytes are searched, and the previous one which can be a CR
+		from := maxInt64(int64(split), int64(len(data)-n-1))
+		if end := getLastLineEnd(data[from:]); end > 0 {
+			splitter.add(data[split : int(from)+end])
+			split = int(from) + end
+		}
+		if progress != nil {
+			progress(LoadProgress{BytesRead: counter.count, TotalBytes: totalBytes, LinesFound: splitter.getLineCount()})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	doc.close()
+	doc.setTable(NewPieceTable(data), splitter.finish(data[split:]))
+	doc.maxPartSize = maxPartSize
+	doc.charset = charset
+	doc.bom = bomSize > 0
+	doc.fileLength = counter.count
+	fmt.Printf("Document.LoadFromReader() took %dms\n", time.Since(startTime).Milliseconds())
+	doc.fireReloaded()
+	return nil
+}
+
+// LoadFromFile reads the document from the given file, see LoadFromReader.
+func (doc *Document) LoadFromFile(ctx context.Context, file string, charset string, maxPartSize int, progress func(LoadProgress)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	totalBytes := int64(-1)
+	if info, err := f.Stat(); err == nil {
+		totalBytes = info.Size()
+	}
+	return doc.LoadFromReader(ctx, f, charset, totalBytes, maxPartSize, progress)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"unsafe"
+)
+
+// ErrMappedFileTruncated is returned when reading a part of a mapped file removed by another program
+var ErrMappedFileTruncated = errors.New("the mapped file was truncated")
+
+// MappedFile is a file mapped read only in memory.
+// Its pages are only read from the disk when accessed and can be dropped by the OS at any time.
+// The file itself is mapped, without copy: another program can still modify or truncate it.
+// Reading the pages after the new end of a truncated file raises a fault, the reads are done in read
+// to return ErrMappedFileTruncated instead of stopping the editor.
+type MappedFile struct {
+	data  []byte
+	info  os.FileInfo
+	unmap func() error
+	// address is the start of the mapped memory, to check that a fault is a read of the mapped file
+	address uintptr
+	// mutex protects refs and closed, the snapshots of a document can be released by other goroutines
+	mutex  sync.Mutex
+	refs   int
+	closed bool
+}
+
+// OpenMappedFile maps the whole content of the given file.
+func OpenMappedFile(file string) (*MappedFile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return &MappedFile{data: []byte{}, info: info}, nil
+	}
+
+	data, unmap, err := mmapFile(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	return &MappedFile{data: data, info: info, unmap: unmap, address: uintptr(unsafe.Pointer(unsafe.SliceData(data)))}, nil
+}
+
+// read calls f, which reads the mapped content, and returns ErrMappedFileTruncated if f reads a page
+// after the end of the file truncated by another program. The other faults are not recovered.
+func (m *MappedFile) read(f func()) (err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		fault, ok := r.(interface{ Addr() uintptr })
+		if !ok || m.address == 0 || fault.Addr() < m.address || fault.Addr()-m.address >= uintptr(m.info.Size()) {
+			panic(r)
+		}
+		err = fmt.Errorf("%w: cannot read at %d", ErrMappedFileTruncated, fault.Addr()-m.address)
+	}()
+	f()
+	return nil
+}
+
+// Bytes returns the mapped content, it must not be used after Close.
+func (m *MappedFile) Bytes() []byte {
+	return m.data
+}
+
+// Length returns the size of the mapped file.
+func (m *MappedFile) Length() int64 {
+	return int64(len(m.data))
+}
+
+// Stat returns the information about the file when it was mapped.
+func (m *MappedFile) Stat() os.FileInfo {
+	return m.info
+}
+
+// Close unmaps the file, or only when the last reference is released.
+func (m *MappedFile) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.data = nil
+	m.closed = true
+	if m.refs > 0 {
+		return nil
+	}
+	return m.doUnmap()
+}
+
+// retain keeps the file mapped until release is called, even if it is closed
+func (m *MappedFile) retain() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.refs++
+}
+
+// release releases a reference taken by retain, the file is unmapped if it is closed and not referenced anymore
+func (m *MappedFile) release() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.refs--
+	if m.refs > 0 || !m.closed {
+		return nil
+	}
+	return m.doUnmap()
+}
+
+func (m *MappedFile) doUnmap() error {
+	if m.unmap == nil {
+		return nil
+	}
+	err := m.unmap()
+	m.unmap = nil
+	return err
+}
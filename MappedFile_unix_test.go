@@ -0,0 +1,55 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMappedFileTruncated(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "big.txt")
+	if err := os.WriteFile(file, bytes.Repeat([]byte("0123456789abcde\n"), 100000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := NewDocument()
+	if err := doc.LoadMapped(file, 0, UTF8, CHUNK_SIZE); err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	if doc.GetLineCount() != 100001 || doc.GetLine(50000).GetText() != doc.GetLine(0).GetText() {
+		t.Fatal(doc.GetLineCount())
+	}
+	snapshot := doc.Snapshot()
+	defer snapshot.Release()
+
+	// another program truncates the file, the reads after its new end fail instead of stopping the editor
+	if err := os.Truncate(file, 10); err != nil {
+		t.Fatal(err)
+	}
+	if line := doc.GetLine(90000); line.Length() != 0 || doc.GetLineCount() != 100001 {
+		t.Fatal(line, doc.GetLineCount())
+	}
+	if text := doc.GetText(doc.GetTotalLength()-16, doc.GetTotalLength()); text != "" {
+		t.Fatal(text)
+	}
+	if err := doc.Save(filepath.Join(dir, "copy.txt"), UTF8, AUTO); !errors.Is(err, ErrMappedFileTruncated) {
+		t.Fatal(err)
+	}
+	if _, err := snapshot.GetStatistics(context.Background(), nil); !errors.Is(err, ErrMappedFileTruncated) {
+		t.Fatal(err)
+	}
+	// the other faults still panic
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic")
+		}
+	}()
+	var line *Line
+	doc.readMapped(func() { _ = line.lineIndex })
+}
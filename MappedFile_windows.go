@@ -0,0 +1,32 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// mmapFile maps the file, Windows refuses to truncate a mapped file
+func mmapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	h, err := windows.CreateFileMapping(windows.Handle(f.Fd()), nil, windows.PAGE_READONLY, uint32(size>>32), uint32(size), nil)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("CreateFileMapping", err)
+	}
+	addr, err := windows.MapViewOfFile(h, windows.FILE_MAP_READ, 0, 0, uintptr(size))
+	if err != nil {
+		windows.CloseHandle(h)
+		return nil, nil, os.NewSyscallError("MapViewOfFile", err)
+	}
+	// addr is the address of the view, outside of the memory managed by Go: the garbage collector
+	// doesn't move nor free it, the slice is valid until UnmapViewOfFile
+	data := unsafe.Slice((*byte)(unsafe.Pointer(addr)), size)
+	unmap := func() error {
+		err := windows.UnmapViewOfFile(addr)
+		windows.CloseHandle(h)
+		return err
+	}
+	return data, unmap, nil
+}
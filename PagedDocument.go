@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// PAGE_SIZE is the size of the pages of a PagedDocument
+const PAGE_SIZE = 4 * 1024 * 1024
+
+// WINDOW_PAGES is the number of pages of a PagedDocument kept in memory: the page around the visible text and its neighbours
+const WINDOW_PAGES = 3
+
+// PAGED_FILE_SIZE is the file length above which the editor opens a file as a PagedDocument
+const PAGED_FILE_SIZE = 1024 * 1024 * 1024
+
+// PagedDocument edits a file larger than the memory: only a window of WINDOW_PAGES pages is loaded in a Document,
+// and the neighbouring pages are loaded when the window moves.
+// The text is a list of pieces, like a PieceTable whose original buffer is the file on disk:
+// SOURCE_ORIGINAL pieces are ranges of the file and SOURCE_ADD pieces are inserted text.
+// When the window moves, the edits of the window document are kept as pieces, and the window document is loaded again,
+// so its undo history doesn't go beyond the window moves. Save streams the unchanged ranges straight from the file.
+// The window starts and ends at line boundaries, unless a line is longer than a page.
+// Only UTF-8 files are supported, and the ends of line are saved as they are.
+// The global indexes of a PagedDocument are byte offsets in its whole text, the indexes of the window document
+// are relative to GetWindowStart.
+// A paged document is not safe for concurrent use, the caller holds the lock of the window document.
+type PagedDocument struct {
+	fileName string
+	file     *os.File
+	// charset is the charset detected when the file was opened, a paged document is always in UTF-8
+	charset string
+	// bomLength is the length of the byte order mark at the beginning of the file, the text starts after it
+	bomLength int64
+	pieces    []piece
+	add       []byte
+	// length is the length of the pieces, the window document can have another length after its edits
+	length      int64
+	pageSize    int64
+	maxPartSize int
+	doc         *Document
+	windowStart int64
+	// windowPieces are the pieces of the text loaded in the window document, the original buffer of its piece table
+	windowPieces  []piece
+	windowLength  int64
+	windowVersion int64
+	// modified is true if edits were kept in the pieces
+	modified bool
+}
+
+// OpenPagedDocument opens a file as a paged document, with a window loaded around the given global index.
+// The file stays open until Close is called.
+func OpenPagedDocument(fileName string, charset string, index int64, maxPartSize int) (*PagedDocument, error) {
+	if !IsUTF8(charset) && !IsAutoCharset(charset) {
+		return nil, fmt.Errorf("a paged document must be in UTF-8, not %s", charset)
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	head := make([]byte, minInt64(info.Size(), PRELOAD_SIZE))
+	if _, err := io.ReadFull(file, head); err != nil {
+		file.Close()
+		return nil, err
+	}
+	text, charset, _ := stripBOM(head, 0, resolveCharset(head, charset))
+	if !IsUTF8(charset) {
+		file.Close()
+		return nil, fmt.Errorf("a paged document must be in UTF-8, not %s", charset)
+	}
+	p := &PagedDocument{
+		fileName:    fileName,
+		charset:     charset,
+		pageSize:    PAGE_SIZE,
+		maxPartSize: maxPartSize,
+		doc:         NewDocument(),
+	}
+	p.open(file, int64(len(head)-len(text)), info.Size())
+	if err := p.loadWindow(index); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// open uses the file as original text, without edits
+func (p *PagedDocument) open(file *os.File, bomLength int64, fileLength int64) {
+	p.file = file
+	p.bomLength = bomLength
+	p.add = nil
+	p.modified = false
+	p.length = fileLength - bomLength
+	p.pieces = nil
+	if p.length > 0 {
+		p.pieces = []piece{{source: SOURCE_ORIGINAL, start: bomLength, length: p.length}}
+	}
+}
+
+// GetDocument returns the window document, the same document after the window moves.
+func (p *PagedDocument) GetDocument() *Document {
+	return p.doc
+}
+
+// GetCharset returns the charset of the file.
+func (p *PagedDocument) GetCharset() string {
+	return p.charset
+}
+
+// GetFileName returns the name of the opened file.
+func (p *PagedDocument) GetFileName() string {
+	return p.fileName
+}
+
+// GetLength returns the length of the whole text, edits included.
+func (p *PagedDocument) GetLength() int64 {
+	return p.length - p.windowLength + p.doc.GetTotalLength()
+}
+
+// GetWindowStart returns the global index of the first character of the window document.
+func (p *PagedDocument) GetWindowStart() int64 {
+	return p.windowStart
+}
+
+// GetWindowEnd returns the global index following the last character of the window document.
+func (p *PagedDocument) GetWindowEnd() int64 {
+	return p.windowStart + p.doc.GetTotalLength()
+}
+
+// HasPreviousPage returns true if text precedes the window.
+func (p *PagedDocument) HasPreviousPage() bool {
+	return p.windowStart > 0
+}
+
+// HasNextPage returns true if text follows the window.
+func (p *PagedDocument) HasNextPage() bool {
+	return p.GetWindowEnd() < p.GetLength()
+}
+
+// IsModified returns true if the text is not the one of the file anymore.
+func (p *PagedDocument) IsModified() bool {
+	return p.modified || p.doc.GetVersion() != p.windowVersion
+}
+
+// LoadNextPage moves the window forward, the end of the window is then in the middle of the new window.
+func (p *PagedDocument) LoadNextPage() error {
+	return p.MoveWindow(p.GetWindowEnd())
+}
+
+// LoadPreviousPage moves the window backward, the start of the window is then in the middle of the new window.
+func (p *PagedDocument) LoadPreviousPage() error {
+	return p.MoveWindow(p.windowStart)
+}
+
+// MoveWindow keeps the edits of the window document, and loads in it the pages around the given global index.
+// The listeners of the window document are notified with EVENT_RELOADED.
+func (p *PagedDocument) MoveWindow(globalIndex int64) error {
+	if globalIndex < 0 || globalIndex > p.GetLength() {
+		panic(fmt.Sprintf("invalid index %d, length is %d", globalIndex, p.GetLength()))
+	}
+	pieces, length, modified := p.pieces, p.length, p.modified
+	p.commitWindow()
+	if err := p.loadWindow(globalIndex); err != nil {
+		// the window document is not loaded again, its edits stay in it
+		p.pieces, p.length, p.modified = pieces, length, modified
+		return err
+	}
+	return nil
+}
+
+// commitWindow replaces the text of the window in the pieces by the edited text of the window document,
+// the window must be loaded again after
+func (p *PagedDocument) commitWindow() {
+	if p.doc.GetVersion() == p.windowVersion {
+		return
+	}
+	table := p.doc.table
+	windowPieces := table.Pieces(0, table.Length())
+	edited := make([]piece, 0, len(windowPieces))
+	for _, windowPiece := range windowPieces {
+		if windowPiece.source == SOURCE_ORIGINAL {
+			edited = appendPieces(edited, subPieces(p.windowPieces, windowPiece.start, windowPiece.start+windowPiece.length)...)
+			continue
+		}
+		// the inserted text is copied, the buffers of the window document are dropped by the next load
+		start := int64(len(p.add))
+		p.add = append(p.add, table.add[windowPiece.start:windowPiece.start+windowPiece.length]...)
+		edited = appendPieces(edited, piece{source: SOURCE_ADD, start: start, length: windowPiece.length})
+	}
+	pieces := subPieces(p.pieces, 0, p.windowStart)
+	pieces = appendPieces(pieces, edited...)
+	pieces = appendPieces(pieces, subPieces(p.pieces, p.windowStart+p.windowLength, p.length)...)
+	p.pieces = pieces
+	p.length += table.Length() - p.windowLength
+	p.modified = true
+}
+
+// loadWindow loads in the window document the pages around the global index, the window must be committed
+func (p *PagedDocument) loadWindow(globalIndex int64) error {
+	size := p.pageSize * WINDOW_PAGES
+	start := maxInt64(0, globalIndex-size/2)
+	end := minInt64(p.length, start+size)
+	start = maxInt64(0, end-size)
+	var err error
+	if start, err = p.findLineStart(start); err != nil {
+		return err
+	}
+	if end, err = p.findLineStart(end); err != nil {
+		return err
+	}
+	text, err := p.readText(start, end)
+	if err != nil {
+		return err
+	}
+	p.doc.loadFromBytes(text, p.maxPartSize)
+	p.doc.charset = p.charset
+	p.doc.bom = p.bomLength > 0
+	p.windowStart = start
+	p.windowPieces = subPieces(p.pieces, start, end)
+	p.windowLength = end - start
+	p.doc.fireReloaded()
+	p.windowVersion = p.doc.GetVersion()
+	return nil
+}
+
+// findLineStart returns the global index of the first line starting at index or after, in the next page.
+// If there is none, a long line is cut at the next code point.
+func (p *PagedDocument) findLineStart(index int64) (int64, error) {
+	if index == 0 || index == p.length {
+		return index, nil
+	}
+	// the byte before index tells if a line starts at index, the byte after the page tells if a CR is a CRLF
+	data, err := p.readText(index-1, minInt64(p.length, index+p.pageSize+1))
+	if err != nil {
+		return 0, err
+	}
+	for i, c := range data {
+		if c == '\n' || (c == '\r' && i+1 < len(data) && data[i+1] != '\n') {
+			return index + int64(i), nil
+		}
+		if c == '\r' && index+int64(i) == p.length {
+			return p.length, nil
+		}
+	}
+	for i := 1; i < len(data) && i <= utf8.UTFMax; i++ {
+		if utf8.RuneStart(data[i]) {
+			return index - 1 + int64(i), nil
+		}
+	}
+	return index, nil
+}
+
+// readText reads the text between the global indexes start and end of the pieces
+func (p *PagedDocument) readText(start, end int64) ([]byte, error) {
+	text := make([]byte, 0, end-start)
+	for _, textPiece := range subPieces(p.pieces, start, end) {
+		if textPiece.source == SOURCE_ADD {
+			text = append(text, p.add[textPiece.start:textPiece.start+textPiece.length]...)
+			continue
+		}
+		n := len(text)
+		text = text[:n+int(textPiece.length)]
+		if _, err := p.file.ReadAt(text[n:], textPiece.start); err != nil {
+			return nil, fmt.Errorf("cannot read %s: %w", p.fileName, err)
+		}
+	}
+	return text, nil
+}
+
+// Save saves the whole text in UTF-8, the unchanged pieces are copied from the opened file.
+// The file is replaced atomically like with Document.SaveWithOptions. When the opened file is replaced,
+// it is opened again and the window is loaded again from it, which clears the undo history.
+func (p *PagedDocument) Save(fileName string, options SaveOptions) error {
+	inPlace := p.isOpened(fileName)
+	write := func(w io.Writer) error {
+		return p.writeTo(w, options)
+	}
+	backup := func(target string, overwritten bool) error {
+		return backupFile(target, options.Backup, options.BackupCount, overwritten)
+	}
+	if !inPlace {
+		err := writeFileAtomic(fileName, write, backup, nil)
+		if err == nil {
+			p.doc.fireSaved(fileName)
+		}
+		return err
+	}
+
+	// the opened file is read while writing, it is closed just before being replaced
+	closed := false
+	err := writeFileAtomic(fileName, write, backup, func() error {
+		closed = true
+		return p.file.Close()
+	})
+	if !closed {
+		return err
+	}
+	// the file is replaced once renamed, or overwritten even partially
+	var saveErr *SaveError
+	replaced := err == nil || (errors.As(err, &saveErr) && (saveErr.Step == SAVE_STEP_SYNC_DIR || saveErr.Step == SAVE_STEP_OVERWRITE))
+	file, openErr := os.Open(fileName)
+	if openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	if !replaced {
+		// the previous file is still there, with the same content
+		p.file = file
+		return err
+	}
+	info, statErr := file.Stat()
+	if statErr != nil {
+		file.Close()
+		return errors.Join(err, statErr)
+	}
+	bomLength := int64(0)
+	if options.ByteOrderMark == BOM_ADD || (options.ByteOrderMark == BOM_AUTO && p.bomLength > 0) {
+		bomLength = int64(len(BOM_UTF8))
+	}
+	start := p.windowStart
+	p.open(file, bomLength, info.Size())
+	if loadErr := p.loadWindow(start + minInt64(p.length-start, p.pageSize*WINDOW_PAGES/2)); loadErr != nil {
+		return errors.Join(err, loadErr)
+	}
+	if err != nil {
+		return err
+	}
+	p.doc.fireSaved(fileName)
+	return nil
+}
+
+// writeTo writes the pieces before the window, the window document and the pieces after the window
+func (p *PagedDocument) writeTo(w io.Writer, options SaveOptions) error {
+	out := bufio.NewWriterSize(w, CHUNK_SIZE)
+	if options.ByteOrderMark == BOM_ADD || (options.ByteOrderMark == BOM_AUTO && p.bomLength > 0) {
+		if _, err := out.Write(BOM_UTF8); err != nil {
+			return err
+		}
+	}
+	if err := p.writePieces(out, subPieces(p.pieces, 0, p.windowStart)); err != nil {
+		return err
+	}
+	if _, err := p.doc.table.WriteTo(out); err != nil {
+		return err
+	}
+	if err := p.writePieces(out, subPieces(p.pieces, p.windowStart+p.windowLength, p.length)); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func (p *PagedDocument) writePieces(out *bufio.Writer, pieces []piece) error {
+	for _, textPiece := range pieces {
+		if textPiece.source == SOURCE_ADD {
+			if _, err := out.Write(p.add[textPiece.start : textPiece.start+textPiece.length]); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := io.Copy(out, io.NewSectionReader(p.file, textPiece.start, textPiece.length)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isOpened returns true if the given file is the opened file
+func (p *PagedDocument) isOpened(fileName string) bool {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return false
+	}
+	opened, err := p.file.Stat()
+	if err != nil {
+		return false
+	}
+	return os.SameFile(info, opened)
+}
+
+// Close closes the file, the window document is emptied.
+func (p *PagedDocument) Close() error {
+	p.doc.LoadFromString("", p.maxPartSize)
+	p.pieces = nil
+	p.add = nil
+	p.length = 0
+	p.windowStart = 0
+	p.windowPieces = nil
+	p.windowLength = 0
+	p.windowVersion = p.doc.GetVersion()
+	return p.file.Close()
+}
+
+// String returns a string representation of the paged document.
+func (p *PagedDocument) String() string {
+	return fmt.Sprintf("PagedDocument [file=%s, length=%d, pieces=%d, windowStart=%d, windowLength=%d]",
+		p.fileName, p.GetLength(), len(p.pieces), p.windowStart, p.doc.GetTotalLength())
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// PARALLEL_BLOCK_SIZE is the size of the blocks split into lines in parallel by splitLineLengths
+const PARALLEL_BLOCK_SIZE = 4 * 1024 * 1024
+
+// parallelSplitter finds the lines of blocks of bytes in parallel goroutines, one per CPU,
+// and joins the lengths of the lines in order. The result is the same as lineLengths of the whole text.
+// The lines themselves are created when needed, from the piece table and these lengths.
+type parallelSplitter struct {
+	// fragments are the lengths of the lines of each block, in order
+	fragments []*[]int64
+	wg        sync.WaitGroup
+	workers   chan struct{}
+	lineCount atomic.Int64
+}
+
+func newParallelSplitter() *parallelSplitter {
+	return &parallelSplitter{
+		workers: make(chan struct{}, runtime.NumCPU()),
+	}
+}
+
+// add splits the block in background, it must end with a complete end of line, see getLastLineEnd.
+// add blocks while all the goroutines are busy.
+func (s *parallelSplitter) add(block []byte) {
+	fragment := new([]int64)
+	s.fragments = append(s.fragments, fragment)
+	s.workers <- struct{}{}
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			<-s.workers
+			s.wg.Done()
+		}()
+		*fragment = lineLengths(block, false)
+		s.lineCount.Add(int64(len(*fragment)))
+	}()
+}
+
+// getLineCount returns the number of lines of the blocks already split.
+func (s *parallelSplitter) getLineCount() int {
+	return int(s.lineCount.Load())
+}
+
+// finish splits the last bytes, which don't need to end with an end of line, waits for the other blocks
+// and returns the lengths of all the lines
+func (s *parallelSplitter) finish(last []byte) []int64 {
+	lastLengths := lineLengths(last, true)
+	s.wg.Wait()
+
+	count := len(lastLengths)
+	for _, fragment := range s.fragments {
+		count += len(*fragment)
+	}
+	lengths := make([]int64, 0, count)
+	for _, fragment := range append(s.fragments, &lastLengths) {
+		lengths = append(lengths, *fragment...)
+		*fragment = nil
+	}
+	return lengths
+}
+
+// lineLengths returns the lengths, with end of line, of the lines of data ending with LF, CRLF or CR.
+// If last is true, the length of the last line, after the last end of line, is added: it can be 0.
+// A CR at the end of data ends a line.
+func lineLengths(data []byte, last bool) []int64 {
+	var lengths []int64
+	start := 0
+	for {
+		i := bytes.IndexAny(data[start:], "\r\n")
+		if i < 0 {
+			break
+		}
+		end := start + i + 1
+		if data[end-1] == '\r' && end < len(data) && data[end] == '\n' {
+			end++
+		}
+		lengths = append(lengths, int64(end-start))
+		start = end
+	}
+	if last {
+		lengths = append(lengths, int64(len(data)-start))
+	}
+	return lengths
+}
+
+// getLastLineEnd returns the index following the last complete end of line of data, 0 if there is none.
+// A CR at the very end is not complete, it could be followed by a LF.
+func getLastLineEnd(data []byte) int {
+	end := len(data)
+	if end > 0 && data[end-1] == '\r' {
+		end--
+	}
+	return bytes.LastIndexAny(data[:end], "\r\n") + 1
+}
+
+// splitLineLengths returns the lengths of the lines of data, computed in parallel for large data
+func splitLineLengths(data []byte) []int64 {
+	splitter := newParallelSplitter()
+	for len(data) > PARALLEL_BLOCK_SIZE {
+		end := getLastLineEnd(data[:PARALLEL_BLOCK_SIZE])
+		if end == 0 {
+			// a very long line, until the next end of line
+			next := bytes.IndexAny(data[PARALLEL_BLOCK_SIZE:], "\r\n")
+			if next < 0 {
+				break
+			}
+			end = PARALLEL_BLOCK_SIZE + next + 1
+			if data[end-1] == '\r' {
+				if end == len(data) {
+					break
+				}
+				if data[end] == '\n' {
+					end++
+				}
+			}
+		}
+		splitter.add(data[:end])
+		data = data[end:]
+	}
+	return splitter.finish(data)
+}
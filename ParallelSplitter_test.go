@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// splitSequentially splits data with a single lineSplitter, as before the parallel split
+func splitSequentially(data []byte, maxPartSize int) []Line {
+	s := newLineSplitter(maxPartSize, 0)
+	s.write(data)
+	return s.finish()
+}
+
+// randomText returns size bytes of the alphabet
+func randomText(r *rand.Rand, size int, alphabet string) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return data
+}
+
+func TestParallelSplitMatchesSequential(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	texts := [][]byte{
+		nil,
+		[]byte("\r"),
+		[]byte("\r\n"),
+		randomText(r, 100, "ab\r\n"),
+		// CR LF cut at the limit of the blocks
+		append(bytes.Repeat([]byte("a"), PARALLEL_BLOCK_SIZE-1), "\r\nb"...),
+		// a line longer than a block
+		append(bytes.Repeat([]byte("x"), PARALLEL_BLOCK_SIZE+10), "\rend"...),
+		randomText(r, 2*PARALLEL_BLOCK_SIZE, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa\r\n"),
+		randomText(r, 3*PARALLEL_BLOCK_SIZE+1, "abababababababababababababababababababababababababababababababababab\r"),
+	}
+	for i, data := range texts {
+		expected := splitSequentially(data, 64)
+		lengths := make([]int64, len(expected))
+		for j := range expected {
+			lengths[j] = expected[j].GetLengthWithEOL()
+		}
+		if got := splitLineLengths(data); !reflect.DeepEqual(lengths, got) {
+			t.Fatalf("text %d: %d line lengths, expected %d", i, len(got), len(lengths))
+		}
+		doc := NewDocument()
+		if err := doc.LoadFromReader(context.Background(), bytes.NewReader(data), UTF8, int64(len(data)), 64, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, doc.GetLines()) {
+			t.Fatalf("text %d: the loaded lines differ", i)
+		}
+	}
+}
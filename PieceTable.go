@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// pieceSource tells which buffer of the PieceTable a piece refers to.
+type pieceSource int
+
+const (
+	SOURCE_ORIGINAL pieceSource = iota
+	SOURCE_ADD
+)
+
+// piece is a slice of one of the PieceTable buffers.
+type piece struct {
+	source pieceSource
+	start  int64
+	length int64
+}
+
+// PIECES_PER_CHUNK is the maximum number of pieces of a chunk of a PieceTable
+const PIECES_PER_CHUNK = 512
+
+// pieceChunk is a group of consecutive pieces of a PieceTable, a chunk is not modified once created
+type pieceChunk struct {
+	pieces []piece
+	// ends[i] is the offset following pieces[i] in the chunk
+	ends []int64
+}
+
+func newPieceChunk(pieces []piece) *pieceChunk {
+	c := &pieceChunk{pieces: pieces, ends: make([]int64, len(pieces))}
+	end := int64(0)
+	for i, p := range pieces {
+		end += p.length
+		c.ends[i] = end
+	}
+	return c
+}
+
+// length returns the number of bytes of the pieces of the chunk
+func (c *pieceChunk) length() int64 {
+	return c.ends[len(c.ends)-1]
+}
+
+// PieceTable stores a text as a sequence of pieces pointing either into the
+// original (read only) buffer or into an append only add buffer.
+// Inserting or deleting only splits the pieces around the edit, so the cost
+// doesn't depend on where the edit happens in the text.
+// The pieces are grouped in chunks of at most PIECES_PER_CHUNK pieces, indexed by a Fenwick tree of their lengths:
+// finding the piece at an offset is O(log n), and an edit only creates again the chunks it touches.
+type PieceTable struct {
+	original []byte
+	add      []byte
+	chunks   []*pieceChunk
+	// lengths is the tree of the lengths of the chunks
+	lengths    fenwickTree
+	pieceCount int
+	length     int64
+	// shared is true if chunks and lengths are also used by a snapshot, they are copied before being modified.
+	// The chunks themselves are never modified.
+	shared bool
+}
+
+// NewPieceTable creates a PieceTable on top of the given original content.
+// The content is not copied and must not be modified afterwards.
+func NewPieceTable(original []byte) *PieceTable {
+	t := &PieceTable{
+		original: original,
+		length:   int64(len(original)),
+	}
+	if len(original) > 0 {
+		t.setPieces([]piece{{source: SOURCE_ORIGINAL, start: 0, length: int64(len(original))}})
+	} else {
+		t.setPieces(nil)
+	}
+	return t
+}
+
+// setPieces replaces all the pieces
+func (t *PieceTable) setPieces(pieces []piece) {
+	t.chunks = splitPieceChunks(pieces)
+	t.pieceCount = len(pieces)
+	t.indexChunks()
+}
+
+// splitPieceChunks cuts the pieces in chunks of the same size, at most PIECES_PER_CHUNK pieces
+func splitPieceChunks(pieces []piece) []*pieceChunk {
+	if len(pieces) == 0 {
+		return nil
+	}
+	count := (len(pieces) + PIECES_PER_CHUNK - 1) / PIECES_PER_CHUNK
+	chunks := make([]*pieceChunk, count)
+	start := 0
+	for i := range chunks {
+		end := len(pieces) * (i + 1) / count
+		chunks[i] = newPieceChunk(pieces[start:end:end])
+		start = end
+	}
+	return chunks
+}
+
+// indexChunks creates again the tree of the lengths of the chunks
+func (t *PieceTable) indexChunks() {
+	lengths := make([]int64, len(t.chunks))
+	for i, c := range t.chunks {
+		lengths[i] = c.length()
+	}
+	t.lengths = newFenwickTree(lengths)
+}
+
+// snapshot returns a table with the current text, which doesn't change when this table is edited.
+// The buffers are shared: original is never modified, and the add buffer is only appended to.
+// The chunks are shared until the next edit.
+func (t *PieceTable) snapshot() *PieceTable {
+	t.shared = true
+	return &PieceTable{
+		original:   t.original,
+		add:        t.add[:len(t.add):len(t.add)],
+		chunks:     t.chunks,
+		lengths:    t.lengths,
+		pieceCount: t.pieceCount,
+		length:     t.length,
+		shared:     true,
+	}
+}
+
+// unshare copies the list of chunks and their lengths shared with a snapshot before modifying them
+func (t *PieceTable) unshare() {
+	if t.shared {
+		t.chunks = slices.Clone(t.chunks)
+		t.lengths = slices.Clone(t.lengths)
+		t.shared = false
+	}
+}
+
+// Length returns the number of bytes of the text.
+func (t *PieceTable) Length() int64 {
+	return t.length
+}
+
+// GetPieceCount returns the number of pieces.
+func (t *PieceTable) GetPieceCount() int {
+	return t.pieceCount
+}
+
+func (t *PieceTable) bytesOf(p piece) []byte {
+	if p.source == SOURCE_ADD {
+		return t.add[p.start : p.start+p.length]
+	}
+	return t.original[p.start : p.start+p.length]
+}
+
+// locate returns the chunk and the index in this chunk of the piece containing offset, and the offset inside this piece.
+// If offset is the length of the text, the returned chunk is the number of chunks.
+func (t *PieceTable) locate(offset int64) (int, int, int64) {
+	chunk, remaining := t.lengths.find(offset)
+	if chunk >= len(t.chunks) {
+		return len(t.chunks), 0, remaining
+	}
+	ends := t.chunks[chunk].ends
+	i, _ := slices.BinarySearch(ends, remaining+1)
+	if i > 0 {
+		remaining -= ends[i-1]
+	}
+	return chunk, i, remaining
+}
+
+// chunkOf returns the chunk containing the byte at offset, offset must be lower than the length
+func (t *PieceTable) chunkOf(offset int64) int {
+	chunk, _ := t.lengths.find(offset)
+	return chunk
+}
+
+// replace replaces the bytes between start (inclusive) and end (exclusive) by the pieces.
+// Only the chunks containing start and end are created again, with the chunk of the previous piece
+// to merge the pieces following each other in the same buffer, like the characters typed one after the other.
+func (t *PieceTable) replace(start, end int64, pieces []piece) {
+	t.unshare()
+	length := piecesLength(pieces)
+	if len(t.chunks) == 0 {
+		t.setPieces(appendPieces(nil, pieces...))
+		t.length = length
+		return
+	}
+	firstChunk := t.chunkOf(maxInt64(0, start-1))
+	lastChunk := t.chunkOf(minInt64(end, t.length-1))
+	base := t.lengths.prefix(firstChunk)
+	var old []piece
+	for i := firstChunk; i <= lastChunk; i++ {
+		old = append(old, t.chunks[i].pieces...)
+	}
+	result := subPieces(old, 0, start-base)
+	result = appendPieces(result, pieces...)
+	result = appendPieces(result, subPieces(old, end-base, piecesLength(old))...)
+	if len(result) < PIECES_PER_CHUNK/2 && lastChunk+1 < len(t.chunks) {
+		// merge the small chunk with the next one
+		lastChunk++
+		old = append(old, t.chunks[lastChunk].pieces...)
+		result = appendPieces(result, t.chunks[lastChunk].pieces...)
+	}
+	chunks := splitPieceChunks(result)
+	t.pieceCount += len(result) - len(old)
+	t.length += length - (end - start)
+	if len(chunks) == lastChunk-firstChunk+1 {
+		// same number of chunks, only their lengths change
+		for i, c := range chunks {
+			t.lengths.add(firstChunk+i, c.length()-t.chunks[firstChunk+i].length())
+			t.chunks[firstChunk+i] = c
+		}
+		return
+	}
+	t.chunks = append(t.chunks[:firstChunk:firstChunk], append(chunks, t.chunks[lastChunk+1:]...)...)
+	t.indexChunks()
+}
+
+// Insert inserts text at the given offset.
+func (t *PieceTable) Insert(offset int64, text string) {
+	if offset < 0 || offset > t.length {
+		panic(fmt.Sprintf("invalid offset %d, length is %d", offset, t.length))
+	}
+	if len(text) == 0 {
+		return
+	}
+	start := int64(len(t.add))
+	t.add = append(t.add, text...)
+	// typing sequentially only extends the previous piece
+	t.replace(offset, offset, []piece{{source: SOURCE_ADD, start: start, length: int64(len(text))}})
+}
+
+// Pieces returns the pieces containing the bytes between start (inclusive) and end (exclusive).
+// The pieces can be inserted again with InsertPieces, without copying the bytes.
+func (t *PieceTable) Pieces(start, end int64) []piece {
+	if start < 0 || end < start || end > t.length {
+		panic(fmt.Sprintf("invalid range (%d, %d), length is %d", start, end, t.length))
+	}
+	var result []piece
+	t.forEachPieceBetween(start, end, func(p piece) bool {
+		result = append(result, p)
+		return true
+	})
+	return result
+}
+
+// InsertPieces inserts at the given offset pieces returned by Pieces.
+func (t *PieceTable) InsertPieces(offset int64, pieces []piece) {
+	if offset < 0 || offset > t.length {
+		panic(fmt.Sprintf("invalid offset %d, length is %d", offset, t.length))
+	}
+	if len(pieces) == 0 {
+		return
+	}
+	t.replace(offset, offset, pieces)
+}
+
+// Delete removes the bytes between start (inclusive) and end (exclusive).
+func (t *PieceTable) Delete(start, end int64) {
+	if start < 0 || end < start || end > t.length {
+		panic(fmt.Sprintf("invalid range (%d, %d), length is %d", start, end, t.length))
+	}
+	if start == end {
+		return
+	}
+	t.replace(start, end, nil)
+}
+
+// forEachPieceBetween calls f with the pieces containing the bytes between start (inclusive) and end (exclusive),
+// cut to this range, in order, until f returns false.
+func (t *PieceTable) forEachPieceBetween(start, end int64, f func(p piece) bool) {
+	if start >= end {
+		return
+	}
+	chunk, i, offsetInPiece := t.locate(start)
+	remaining := end - start
+	for ; chunk < len(t.chunks); chunk++ {
+		for _, p := range t.chunks[chunk].pieces[i:] {
+			length := minInt64(p.length-offsetInPiece, remaining)
+			if !f(piece{source: p.source, start: p.start + offsetInPiece, length: length}) {
+				return
+			}
+			remaining -= length
+			if remaining == 0 {
+				return
+			}
+			offsetInPiece = 0
+		}
+		i = 0
+	}
+}
+
+// forEachPiece calls f with the content of each piece, in order, until f returns false.
+func (t *PieceTable) forEachPiece(f func(data []byte) bool) {
+	for _, c := range t.chunks {
+		for _, p := range c.pieces {
+			if !f(t.bytesOf(p)) {
+				return
+			}
+		}
+	}
+}
+
+// scanLineEnds calls f for each end of line (LF, CRLF or a single CR)
+// with the offset following the end of line
+func (t *PieceTable) scanLineEnds(f func(next int64, separator LineSeparator)) {
+	offset := int64(0)
+	pendingReturn := false
+	t.forEachPiece(func(data []byte) bool {
+		start := 0
+		for start < len(data) {
+			if pendingReturn {
+				pendingReturn = false
+				if data[start] == '\n' {
+					f(offset+int64(start)+1, CRLF)
+					start++
+					continue
+				}
+				f(offset+int64(start), CR)
+			}
+			i := bytes.IndexAny(data[start:], "\r\n")
+			if i < 0 {
+				break
+			}
+			start += i
+			if data[start] == '\n' {
+				f(offset+int64(start)+1, LF)
+			} else {
+				pendingReturn = true
+			}
+			start++
+		}
+		offset += int64(len(data))
+		return true
+	})
+	if pendingReturn {
+		f(offset, CR)
+	}
+}
+
+// Bytes returns a copy of the bytes between start (inclusive) and end (exclusive).
+func (t *PieceTable) Bytes(start, end int64) []byte {
+	if start < 0 || end < start || end > t.length {
+		panic(fmt.Sprintf("invalid range (%d, %d), length is %d", start, end, t.length))
+	}
+	result := make([]byte, 0, end-start)
+	t.forEachPieceBetween(start, end, func(p piece) bool {
+		result = append(result, t.bytesOf(p)...)
+		return true
+	})
+	return result
+}
+
+// WriteTo writes the whole text to out.
+func (t *PieceTable) WriteTo(out io.Writer) (int64, error) {
+	written := int64(0)
+	var err error
+	t.forEachPiece(func(data []byte) bool {
+		var n int
+		n, err = out.Write(data)
+		written += int64(n)
+		return err == nil
+	})
+	return written, err
+}
+
+// compactAdd creates again the add buffer with only the bytes referenced by the pieces of the table
+// and by others, and returns others referencing the new buffer. The snapshots keep the previous buffer.
+func (t *PieceTable) compactAdd(others [][]piece) [][]piece {
+	// the referenced ranges of the add buffer, sorted and merged
+	type addRange struct {
+		start, end, newStart int64
+	}
+	var ranges []addRange
+	collect := func(p piece) {
+		if p.source == SOURCE_ADD {
+			ranges = append(ranges, addRange{start: p.start, end: p.start + p.length})
+		}
+	}
+	pieces := t.Pieces(0, t.length)
+	for _, p := range pieces {
+		collect(p)
+	}
+	for _, list := range others {
+		for _, p := range list {
+			collect(p)
+		}
+	}
+	slices.SortFunc(ranges, func(a, b addRange) int {
+		return cmp.Compare(a.start, b.start)
+	})
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.start <= merged[n-1].end {
+			merged[n-1].end = maxInt64(merged[n-1].end, r.end)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	var add []byte
+	for i := range merged {
+		merged[i].newStart = int64(len(add))
+		add = append(add, t.add[merged[i].start:merged[i].end]...)
+	}
+	move := func(list []piece) []piece {
+		moved := make([]piece, len(list))
+		for i, p := range list {
+			if p.source == SOURCE_ADD {
+				j, _ := slices.BinarySearchFunc(merged, p.start, func(r addRange, start int64) int {
+					return cmp.Compare(r.end, start+1)
+				})
+				p.start += merged[j].newStart - merged[j].start
+			}
+			moved[i] = p
+		}
+		return moved
+	}
+	t.add = add
+	t.setPieces(move(pieces))
+	t.shared = false
+	result := make([][]piece, len(others))
+	for i, list := range others {
+		result[i] = move(list)
+	}
+	return result
+}
+
+// String returns the whole text.
+func (t *PieceTable) String() string {
+	return string(t.Bytes(0, t.length))
+}
+
+// subPieces returns the pieces of the text between start and end, the text being the concatenation of pieces
+func subPieces(pieces []piece, start, end int64) []piece {
+	var result []piece
+	offset := int64(0)
+	for _, p := range pieces {
+		pieceEnd := offset + p.length
+		if pieceEnd > start && offset < end {
+			from := maxInt64(start, offset) - offset
+			to := minInt64(end, pieceEnd) - offset
+			result = append(result, piece{source: p.source, start: p.start + from, length: to - from})
+		}
+		if pieceEnd >= end {
+			break
+		}
+		offset = pieceEnd
+	}
+	return result
+}
+
+// appendPieces appends pieces to a list, merging the pieces following each other in the same buffer
+func appendPieces(list []piece, pieces ...piece) []piece {
+	for _, p := range pieces {
+		if n := len(list); n > 0 {
+			last := &list[n-1]
+			if last.source == p.source && last.start+last.length == p.start {
+				last.length += p.length
+				continue
+			}
+		}
+		list = append(list, p)
+	}
+	return list
+}
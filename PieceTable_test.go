@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPieceTableEdits(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	expected := "hello\nworld\r\nfoo"
+	table := NewPieceTable([]byte(expected))
+	for i := 0; i < 3000; i++ {
+		if r.Intn(2) == 0 || len(expected) == 0 {
+			offset := r.Intn(len(expected) + 1)
+			text := []string{"a", "\n", "xy\nz", "\r\n", ""}[r.Intn(5)]
+			table.Insert(int64(offset), text)
+			expected = expected[:offset] + text + expected[offset:]
+		} else {
+			start := r.Intn(len(expected) + 1)
+			end := start + r.Intn(len(expected)-start+1)
+			table.Delete(int64(start), int64(end))
+			expected = expected[:start] + expected[end:]
+		}
+		if table.String() != expected || table.Length() != int64(len(expected)) {
+			t.Fatalf("step %d: %q, expected %q", i, table.String(), expected)
+		}
+		if len(expected) > 0 {
+			start := r.Intn(len(expected))
+			end := start + r.Intn(len(expected)-start+1)
+			if string(table.Bytes(int64(start), int64(end))) != expected[start:end] {
+				t.Fatalf("step %d: wrong bytes from %d to %d", i, start, end)
+			}
+		}
+	}
+}
+
+func TestPieceTableSnapshot(t *testing.T) {
+	table := NewPieceTable([]byte("abc"))
+	table.Insert(3, "def")
+	snapshot := table.snapshot()
+	table.Insert(0, "x")
+	table.Delete(4, 6)
+	if snapshot.String() != "abcdef" || table.String() != "xabcf" {
+		t.Fatal(snapshot.String(), table.String())
+	}
+}
+
+func TestPieceTableLines(t *testing.T) {
+	doc := NewDocument()
+	doc.LoadFromString("a\nb\r\nc", 2)
+	doc.Insert(1, "X\nY")
+	if doc.GetLineCount() != 4 || doc.GetText(0, doc.GetTotalLength()) != "aX\nY\nb\r\nc" {
+		t.Fatal(doc.GetLineCount(), doc.GetText(0, doc.GetTotalLength()))
+	}
+	if lines := doc.GetLines(); len(lines) != 4 || lines[1].parts[0] != "Y" {
+		t.Fatal(lines)
+	}
+}
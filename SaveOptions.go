@@ -0,0 +1,11 @@
+package main
+
+// SaveOptions contains the optional parameters of Document.SaveWithOptions
+type SaveOptions struct {
+	// ByteOrderMark tells if a byte order mark is written, BOM_AUTO by default
+	ByteOrderMark ByteOrderMark
+	// Backup tells if and how the previous version of the file is kept, BACKUP_NONE by default
+	Backup BackupMode
+	// BackupCount is the number of numbered backups kept, DEFAULT_BACKUP_COUNT if not set
+	BackupCount int
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Snapshot is an immutable view of a Document at a given version, for the goroutines reading the document
+// while it is edited, like searches, saves or statistics.
+// Taking a snapshot is O(1): the text is shared with the document, which only copies its list of pieces at the next edit.
+// The lines of the snapshot are created when needed, like the lines of a mapped document.
+// A snapshot can be used by several goroutines. Release must be called when it is not used anymore.
+type Snapshot struct {
+	mutex   sync.Mutex
+	doc     *Document
+	version int64
+	// mapping is the mapped file of the document, kept mapped until the snapshot is released
+	mapping *MappedFile
+}
+
+// Snapshot returns a view of the current content of the document, which is not modified by the next edits.
+func (doc *Document) Snapshot() *Snapshot {
+	snapshot := &Document{
+		table:       doc.table.snapshot(),
+		maxPartSize: doc.maxPartSize,
+		totalLength: -1,
+		charset:     doc.charset,
+		bom:         doc.bom,
+		history:     NewHistory(),
+		fileLength:  doc.fileLength,
+		readOnly:    true,
+		// only used to read the mapped file, the snapshot releases it
+		mapping: doc.mapping,
+	}
+	if doc.mapping != nil {
+		doc.mapping.retain()
+	}
+	return &Snapshot{doc: snapshot, version: doc.version, mapping: doc.mapping}
+}
+
+// GetVersion returns the version of the document when the snapshot was taken, see Document.GetVersion.
+func (s *Snapshot) GetVersion() int64 {
+	return s.version
+}
+
+// GetCharset returns the charset of the document.
+func (s *Snapshot) GetCharset() string {
+	return s.doc.charset
+}
+
+// GetTotalLength returns the length of the text, ends of line included.
+func (s *Snapshot) GetTotalLength() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.doc.GetTotalLength()
+}
+
+// GetLineCount returns the number of lines.
+func (s *Snapshot) GetLineCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.doc.GetLineCount()
+}
+
+// GetLine returns the line at the given index.
+func (s *Snapshot) GetLine(index int) *Line {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.doc.GetLine(index)
+}
+
+// GetLinesRange returns count lines starting at index first.
+func (s *Snapshot) GetLinesRange(first int, count int) []Line {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.doc.GetLinesRange(first, count)
+}
+
+// GetText returns the text between start (inclusive) and end (exclusive) global indexes.
+func (s *Snapshot) GetText(start, end int64) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.doc.GetText(start, end)
+}
+
+// GetIndex returns the line and the index in this line of the character at the given global index.
+func (s *Snapshot) GetIndex(globalIndex int64) *Index {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.doc.GetIndex(globalIndex)
+}
+
+// GetGlobalIndexOfLine returns the global index of the first character of the line.
+func (s *Snapshot) GetGlobalIndexOfLine(lineIndex int) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.doc.GetGlobalIndexOfLine(lineIndex)
+}
+
+// ForEachLine calls f for each line, in order, until f returns an error. f must not use the snapshot.
+func (s *Snapshot) ForEachLine(f func(line *Line) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.doc.forEachLine(f)
+}
+
+// WriteTo writes the text encoded in the given charset, like Document.SaveWithOptions without backup.
+func (s *Snapshot) WriteTo(w io.Writer, charset string, lineSeparator LineSeparator, options SaveOptions) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.doc.writeTo(w, charset, lineSeparator, options)
+}
+
+// Release releases the mapped file of the document, if any. The snapshot must not be used after.
+func (s *Snapshot) Release() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	mapping := s.mapping
+	s.mapping = nil
+	if mapping == nil {
+		return nil
+	}
+	return mapping.release()
+}
+
+// String returns a string representation of the snapshot.
+func (s *Snapshot) String() string {
+	return fmt.Sprintf("Snapshot [version=%d, charset=%s]", s.version, s.doc.charset)
+}
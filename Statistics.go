@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// STATISTICS_BACKGROUND_SIZE is the length above which the statistics of a document are computed in background
+const STATISTICS_BACKGROUND_SIZE = 1 * 1024 * 1024
+
+// STATISTICS_REPORT_SIZE is the number of bytes counted between two reports of partial statistics
+const STATISTICS_REPORT_SIZE = 16 * 1024 * 1024
+
+// STATISTICS_BLOCK_SIZE is the minimum length of the blocks of lines counted separately by a StatisticsUpdater
+const STATISTICS_BLOCK_SIZE = 64 * 1024
+
+// Statistics are facts about the text of a document at a given version.
+// The lengths of the lines are in code points, without the end of line.
+type Statistics struct {
+	Version int64
+	Bytes   int64
+	// Runes is the number of code points, ends of line included. An invalid byte counts as one code point.
+	Runes int64
+	// Words is the number of sequences of characters separated by white spaces
+	Words int64
+	Lines int
+	// LongestLine is the index of the first longest line
+	LongestLine       int
+	LongestLineLength int64
+	EmptyLines        int
+	// LF, CRLF and CR are the number of each end of line
+	LF   int
+	CRLF int
+	CR   int
+	// Complete is false for the partial statistics reported during the computation
+	Complete bool
+}
+
+// String returns a string representation of the statistics.
+func (s Statistics) String() string {
+	return fmt.Sprintf("Statistics [version=%d, bytes=%d, runes=%d, words=%d, lines=%d, longestLine=%d (%d), emptyLines=%d, LF=%d, CRLF=%d, CR=%d, complete=%t]",
+		s.Version, s.Bytes, s.Runes, s.Words, s.Lines, s.LongestLine, s.LongestLineLength, s.EmptyLines, s.LF, s.CRLF, s.CR, s.Complete)
+}
+
+// GetStatistics computes the statistics of the document, see Snapshot.GetStatistics for large documents.
+func (doc *Document) GetStatistics() Statistics {
+	counter := newStatisticsCounter()
+	doc.table.forEachPiece(func(data []byte) bool {
+		counter.write(data)
+		return true
+	})
+	return counter.finish(doc.version)
+}
+
+// GetStatistics computes the statistics of the snapshot. progress, if not nil, is called with partial statistics
+// every STATISTICS_REPORT_SIZE bytes. The computation stops with the error of ctx when it is cancelled.
+func (s *Snapshot) GetStatistics(ctx context.Context, progress func(Statistics)) (Statistics, error) {
+	blocks, err := s.countStatistics(ctx, progress)
+	if err != nil {
+		return Statistics{}, err
+	}
+	return blocks.statistics(s.version), nil
+}
+
+// countStatistics computes the statistics of the blocks of the snapshot
+func (s *Snapshot) countStatistics(ctx context.Context, progress func(Statistics)) (*statisticsBlocks, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	blocks := newStatisticsBlocks()
+	read := int64(0)
+	nextReport := int64(STATISTICS_REPORT_SIZE)
+	var err error
+	readErr := s.doc.readMapped(func() {
+		s.doc.table.forEachPiece(func(data []byte) bool {
+			for len(data) > 0 {
+				if err = ctx.Err(); err != nil {
+					return false
+				}
+				n := int(minInt64(int64(len(data)), CHUNK_SIZE))
+				blocks.write(data[:n])
+				data = data[n:]
+				read += int64(n)
+				if progress != nil && read >= nextReport {
+					nextReport += STATISTICS_REPORT_SIZE
+					progress(blocks.partial(s.version))
+				}
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	return blocks, nil
+}
+
+// statisticsCounter computes the statistics of a text written by blocks
+type statisticsCounter struct {
+	stats Statistics
+	// pending are the bytes of a code point cut between two blocks
+	pending    []byte
+	returnSeen bool
+	inWord     bool
+	lineLength int64
+}
+
+func newStatisticsCounter() *statisticsCounter {
+	c := &statisticsCounter{}
+	c.stats.LongestLineLength = -1
+	return c
+}
+
+func (c *statisticsCounter) write(data []byte) {
+	c.stats.Bytes += int64(len(data))
+	if len(c.pending) > 0 {
+		data = append(c.pending, data...)
+		c.pending = nil
+	}
+	for len(data) > 0 {
+		if data[0] < utf8.RuneSelf {
+			c.addRune(rune(data[0]))
+			data = data[1:]
+			continue
+		}
+		if !utf8.FullRune(data) {
+			c.pending = append([]byte(nil), data...)
+			return
+		}
+		r, size := utf8.DecodeRune(data)
+		c.addRune(r)
+		data = data[size:]
+	}
+}
+
+func (c *statisticsCounter) addRune(r rune) {
+	c.stats.Runes++
+	if c.returnSeen {
+		c.returnSeen = false
+		if r == '\n' {
+			c.stats.CRLF++
+			c.endLine()
+			return
+		}
+		c.stats.CR++
+		c.endLine()
+	}
+	switch {
+	case r == '\n':
+		c.stats.LF++
+		c.endLine()
+	case r == '\r':
+		c.returnSeen = true
+		c.inWord = false
+	case unicode.IsSpace(r):
+		c.inWord = false
+		c.lineLength++
+	default:
+		if !c.inWord {
+			c.inWord = true
+			c.stats.Words++
+		}
+		c.lineLength++
+	}
+}
+
+func (c *statisticsCounter) endLine() {
+	if c.lineLength > c.stats.LongestLineLength {
+		c.stats.LongestLine = c.stats.Lines
+		c.stats.LongestLineLength = c.lineLength
+	}
+	if c.lineLength == 0 {
+		c.stats.EmptyLines++
+	}
+	c.stats.Lines++
+	c.lineLength = 0
+	c.inWord = false
+}
+
+// partial returns the statistics of the lines already ended
+func (c *statisticsCounter) partial(version int64) Statistics {
+	stats := c.stats
+	stats.Version = version
+	if stats.LongestLineLength < 0 {
+		stats.LongestLineLength = 0
+	}
+	return stats
+}
+
+// closeLines counts a CR ending the text, the caller knows that no LF follows
+func (c *statisticsCounter) closeLines() {
+	if c.returnSeen {
+		c.returnSeen = false
+		c.stats.CR++
+		c.endLine()
+	}
+}
+
+// finish counts the last line, which has no end of line, and returns the statistics
+func (c *statisticsCounter) finish(version int64) Statistics {
+	c.closeLines()
+	// the bytes of an incomplete code point are invalid
+	for range c.pending {
+		c.addRune(utf8.RuneError)
+	}
+	c.pending = nil
+	c.endLine()
+	stats := c.partial(version)
+	stats.Complete = true
+	return stats
+}
+
+// statisticsBlocks are the statistics of a text cut in blocks of whole lines of at least STATISTICS_BLOCK_SIZE bytes.
+// An edit counts again only the blocks it modifies, text appended continues the count of the last block.
+type statisticsBlocks struct {
+	// blocks are the counters of the blocks before the last one, each ends with an end of line
+	blocks []*statisticsCounter
+	// last counts the last block, until it is long enough to be cut at an end of line
+	last *statisticsCounter
+}
+
+func newStatisticsBlocks() *statisticsBlocks {
+	return &statisticsBlocks{last: newStatisticsCounter()}
+}
+
+// write counts the text following the text already counted
+func (b *statisticsBlocks) write(data []byte) {
+	for len(data) > 0 {
+		if missing := STATISTICS_BLOCK_SIZE - b.last.stats.Bytes; missing > 0 {
+			n := int(minInt64(missing, int64(len(data))))
+			b.last.write(data[:n])
+			data = data[n:]
+			continue
+		}
+		end := lineEndIndex(data)
+		if end < 0 {
+			b.last.write(data)
+			return
+		}
+		b.last.write(data[:end])
+		b.cut()
+		data = data[end:]
+	}
+}
+
+// cut ends the last block, which ends with an end of line
+func (b *statisticsBlocks) cut() {
+	b.last.closeLines()
+	b.blocks = append(b.blocks, b.last)
+	b.last = newStatisticsCounter()
+}
+
+// lineEndIndex returns the index following the first end of line of data, or -1.
+// A CR at the end of data is not known to be an end of line, a LF can follow it.
+func lineEndIndex(data []byte) int {
+	i := bytes.IndexAny(data, "\r\n")
+	switch {
+	case i < 0:
+		return -1
+	case data[i] == '\n':
+		return i + 1
+	case i+1 == len(data):
+		return -1
+	case data[i+1] == '\n':
+		return i + 2
+	}
+	return i + 1
+}
+
+// replace counts again the blocks modified by the replacement of the bytes start to oldEnd by the bytes start to newEnd
+// of the document. The blocks touching the modified bytes are counted again, with the next block if they become small:
+// a modification at the limit of a block can join its end of line with the next one.
+func (b *statisticsBlocks) replace(doc *Document, start, oldEnd, newEnd int64) {
+	counters := append(b.blocks[:len(b.blocks):len(b.blocks)], b.last)
+	first, last := -1, 0
+	firstStart, lastEnd := int64(0), int64(0)
+	offset := int64(0)
+	for i, c := range counters {
+		end := offset + c.stats.Bytes
+		if first < 0 && end >= start {
+			first, firstStart = i, offset
+		}
+		if offset > oldEnd {
+			break
+		}
+		last, lastEnd = i, end
+		offset = end
+	}
+	rangeEnd := lastEnd + newEnd - oldEnd
+	if last < len(b.blocks) && rangeEnd-firstStart < STATISTICS_BLOCK_SIZE/2 {
+		last++
+		rangeEnd += counters[last].stats.Bytes
+	}
+	counted := newStatisticsBlocks()
+	doc.table.forEachPieceBetween(firstStart, rangeEnd, func(p piece) bool {
+		counted.write(doc.table.bytesOf(p))
+		return true
+	})
+	if last == len(b.blocks) {
+		b.blocks = append(b.blocks[:first], counted.blocks...)
+		b.last = counted.last
+		return
+	}
+	if counted.last.stats.Bytes > 0 {
+		counted.cut()
+	}
+	next := b.blocks[last+1:]
+	b.blocks = append(append(b.blocks[:first:first], counted.blocks...), next...)
+}
+
+// length returns the number of bytes counted
+func (b *statisticsBlocks) length() int64 {
+	length := b.last.stats.Bytes
+	for _, c := range b.blocks {
+		length += c.stats.Bytes
+	}
+	return length
+}
+
+// statistics returns the statistics of the text
+func (b *statisticsBlocks) statistics(version int64) Statistics {
+	last := *b.last
+	stats := b.sum(last.finish(version))
+	stats.Complete = true
+	return stats
+}
+
+// partial returns the statistics of the lines already ended
+func (b *statisticsBlocks) partial(version int64) Statistics {
+	stats := b.sum(b.last.stats)
+	stats.Version = version
+	return stats
+}
+
+// sum adds the statistics of the blocks and of the last block
+func (b *statisticsBlocks) sum(last Statistics) Statistics {
+	sum := Statistics{LongestLineLength: -1}
+	add := func(stats Statistics) {
+		if stats.LongestLineLength > sum.LongestLineLength {
+			sum.LongestLine = sum.Lines + stats.LongestLine
+			sum.LongestLineLength = stats.LongestLineLength
+		}
+		sum.Bytes += stats.Bytes
+		sum.Runes += stats.Runes
+		sum.Words += stats.Words
+		sum.Lines += stats.Lines
+		sum.EmptyLines += stats.EmptyLines
+		sum.LF += stats.LF
+		sum.CRLF += stats.CRLF
+		sum.CR += stats.CR
+	}
+	for _, c := range b.blocks {
+		add(c.stats)
+	}
+	add(last)
+	sum.Version = last.Version
+	if sum.LongestLineLength < 0 {
+		sum.LongestLineLength = 0
+	}
+	return sum
+}
+
+// StatisticsUpdater keeps the statistics of a document up to date. The text is counted by blocks of lines:
+// an edit counts again only the modified blocks, and the text appended, as in follow mode, continues the count.
+// The statistics of a document larger than STATISTICS_BACKGROUND_SIZE are counted in background on a snapshot
+// after a load, partial statistics are reported meanwhile. The edits done during this count are counted after it.
+type StatisticsUpdater struct {
+	doc      *Document
+	onUpdate func(stats Statistics)
+	// runner runs the updates at the end of the count in background, nil to run them in its goroutine
+	runner func(f func())
+	mutex  sync.Mutex
+	blocks *statisticsBlocks
+	// cancel stops the count in background, nil if there is none
+	cancel context.CancelFunc
+	// edited is true if the document was edited during the count in background,
+	// only the editedStart first bytes and the editedSuffix last bytes of the counted text are unchanged
+	edited       bool
+	editedStart  int64
+	editedSuffix int64
+	closed       bool
+}
+
+// NewStatisticsUpdater computes the statistics of the document, then again after each change.
+// onUpdate is called with the statistics from the goroutine editing the document. During a count in background,
+// the partial statistics and the end of the count are passed to run, which runs them in the goroutine editing
+// the document, like fyne.Do. If run is nil, they run in the goroutine of the count.
+// The caller holds the lock of the document.
+func NewStatisticsUpdater(doc *Document, run func(f func()), onUpdate func(stats Statistics)) *StatisticsUpdater {
+	u := &StatisticsUpdater{doc: doc, runner: run, onUpdate: onUpdate}
+	doc.AddDocumentListener(u)
+	u.count()
+	return u
+}
+
+// DocumentChanged updates the statistics after a change of the text
+func (u *StatisticsUpdater) DocumentChanged(event DocumentEvent) {
+	switch event.Type {
+	case EVENT_INSERTED:
+		u.edit(event.Start, event.Start, event.End)
+	case EVENT_REMOVED:
+		u.edit(event.Start, event.End, event.Start)
+	case EVENT_RELOADED:
+		u.count()
+	}
+}
+
+// count counts the whole text, the document is locked
+func (u *StatisticsUpdater) count() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.closed {
+		return
+	}
+	if u.cancel != nil {
+		u.cancel()
+		u.cancel = nil
+	}
+	u.edited = false
+	if u.doc.GetTotalLength() <= STATISTICS_BACKGROUND_SIZE {
+		u.blocks = newStatisticsBlocks()
+		u.doc.table.forEachPiece(func(data []byte) bool {
+			u.blocks.write(data)
+			return true
+		})
+		u.onUpdate(u.blocks.statistics(u.doc.GetVersion()))
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	u.cancel = cancel
+	snapshot := u.doc.Snapshot()
+	go func() {
+		defer snapshot.Release()
+		blocks, err := snapshot.countStatistics(ctx, func(partial Statistics) {
+			u.apply(func() {
+				u.report(ctx, partial)
+			})
+		})
+		if err != nil {
+			return
+		}
+		u.apply(func() {
+			u.finishCount(ctx, blocks)
+		})
+	}()
+}
+
+// apply runs f with the runner
+func (u *StatisticsUpdater) apply(f func()) {
+	if u.runner == nil {
+		f()
+	} else {
+		u.runner(f)
+	}
+}
+
+// finishCount counts the edits done during the count in background, unless it was cancelled
+func (u *StatisticsUpdater) finishCount(ctx context.Context, blocks *statisticsBlocks) {
+	u.doc.Lock()
+	defer u.doc.Unlock()
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	u.cancel()
+	u.cancel = nil
+	if u.edited {
+		counted := blocks.length()
+		blocks.replace(u.doc, u.editedStart, counted-u.editedSuffix, u.doc.GetTotalLength()-u.editedSuffix)
+	}
+	u.blocks = blocks
+	u.onUpdate(blocks.statistics(u.doc.GetVersion()))
+}
+
+// edit updates the statistics after the replacement of the bytes start to oldEnd by the bytes start to newEnd,
+// the document is locked
+func (u *StatisticsUpdater) edit(start, oldEnd, newEnd int64) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.closed {
+		return
+	}
+	length := u.doc.GetTotalLength()
+	if u.cancel != nil {
+		// counted when the count in background ends
+		if !u.edited || start < u.editedStart {
+			u.editedStart = start
+		}
+		if !u.edited || length-newEnd < u.editedSuffix {
+			u.editedSuffix = length - newEnd
+		}
+		u.edited = true
+		return
+	}
+	err := u.doc.readMapped(func() {
+		if start == oldEnd && newEnd == length {
+			// appended
+			u.doc.table.forEachPieceBetween(start, newEnd, func(p piece) bool {
+				u.blocks.write(u.doc.table.bytesOf(p))
+				return true
+			})
+		} else {
+			u.blocks.replace(u.doc, start, oldEnd, newEnd)
+		}
+	})
+	if err != nil {
+		fmt.Printf("Cannot update the statistics: %v\n", err)
+		return
+	}
+	u.onUpdate(u.blocks.statistics(u.doc.GetVersion()))
+}
+
+// report calls onUpdate if the computation was not cancelled by a newer one
+func (u *StatisticsUpdater) report(ctx context.Context, stats Statistics) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if ctx.Err() == nil {
+		u.onUpdate(stats)
+	}
+}
+
+// Close stops updating the statistics. The caller holds the lock of the document.
+func (u *StatisticsUpdater) Close() {
+	u.doc.RemoveDocumentListener(u)
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.closed = true
+	if u.cancel != nil {
+		u.cancel()
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// expectedStatistics computes the statistics of text without the counter of the document
+func expectedStatistics(text string, version int64) Statistics {
+	lines := splitLinesAfter(text)
+	if len(lines) == 0 || strings.HasSuffix(text, "\n") || strings.HasSuffix(text, "\r") {
+		lines = append(lines, "")
+	}
+	s := Statistics{Version: version, Bytes: int64(len(text)), Runes: int64(utf8.RuneCountInString(text)),
+		Words: int64(len(strings.Fields(text))), Lines: len(lines), LongestLineLength: -1, Complete: true}
+	for i, line := range lines {
+		switch {
+		case strings.HasSuffix(line, "\r\n"):
+			s.CRLF++
+			line = line[:len(line)-2]
+		case strings.HasSuffix(line, "\n"):
+			s.LF++
+			line = line[:len(line)-1]
+		case strings.HasSuffix(line, "\r"):
+			s.CR++
+			line = line[:len(line)-1]
+		}
+		n := int64(utf8.RuneCountInString(line))
+		if n > s.LongestLineLength {
+			s.LongestLineLength, s.LongestLine = n, i
+		}
+		if n == 0 {
+			s.EmptyLines++
+		}
+	}
+	return s
+}
+
+// randomStatisticsText returns a text of at least length bytes, with all the ends of line and invalid UTF-8
+func randomStatisticsText(r *rand.Rand, length int) string {
+	alphabet := []string{"a", "bc ", " ", "\n", "\r", "\r\n", "é", "日本", "\t", "\xe2\x82", "\xff", "😀", "\n\n"}
+	var b strings.Builder
+	for b.Len() < length {
+		b.WriteString(alphabet[r.Intn(len(alphabet))])
+	}
+	return b.String()
+}
+
+func TestStatistics(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 300; i++ {
+		text := randomStatisticsText(r, r.Intn(60))
+		doc := NewDocument()
+		doc.LoadFromString(text, 4)
+		// several pieces, with runes cut between them
+		for k := 0; k < 3 && doc.GetTotalLength() > 0; k++ {
+			p := r.Int63n(doc.GetTotalLength())
+			doc.Delete(p, p+1)
+			doc.Insert(p, text[p:p+1])
+		}
+		expected := expectedStatistics(text, doc.GetVersion())
+		if got := doc.GetStatistics(); got != expected {
+			t.Fatalf("%q:\n%v, expected\n%v", text, got, expected)
+		}
+		snapshot := doc.Snapshot()
+		if got, err := snapshot.GetStatistics(context.Background(), nil); err != nil || got != expected {
+			t.Fatalf("snapshot %q:\n%v, expected\n%v", text, got, expected)
+		}
+		snapshot.Release()
+	}
+}
+
+func TestStatisticsIncremental(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for _, size := range []int{0, 100, 300000} {
+		doc := NewDocument()
+		doc.LoadFromString(randomStatisticsText(r, size), CHUNK_SIZE)
+		var got Statistics
+		updater := NewStatisticsUpdater(doc, nil, func(stats Statistics) {
+			got = stats
+		})
+		for i := 0; i < 400; i++ {
+			length := doc.GetTotalLength()
+			switch op := r.Intn(4); {
+			case op == 0 && length > 0:
+				p := r.Int63n(length)
+				doc.Delete(p, minInt64(length, p+r.Int63n(3*STATISTICS_BLOCK_SIZE/2)+1))
+			case op == 1:
+				// as in follow mode
+				doc.appendText(randomStatisticsText(r, r.Intn(100)))
+			case op == 2 && length > 0:
+				// around the end of the first block
+				p := minInt64(length, int64(STATISTICS_BLOCK_SIZE)+r.Int63n(7)-3)
+				doc.Insert(p, randomStatisticsText(r, 1))
+			default:
+				doc.Insert(r.Int63n(length+1), randomStatisticsText(r, r.Intn(2000)))
+			}
+			// the full count
+			if expected := doc.GetStatistics(); got != expected {
+				t.Fatalf("size %d, edit %d:\n%v, expected\n%v", size, i, got, expected)
+			}
+		}
+		updater.Close()
+	}
+}
+
+func TestStatisticsEditedDuringCount(t *testing.T) {
+	doc := NewDocument()
+	doc.LoadFromString(strings.Repeat("hello world\n", STATISTICS_BACKGROUND_SIZE/10), CHUNK_SIZE)
+	// the goroutine of the test runs the updates, as the UI goroutine does
+	queue := make(chan func(), 100)
+	var got Statistics
+	doc.Lock()
+	updater := NewStatisticsUpdater(doc, func(f func()) { queue <- f }, func(stats Statistics) {
+		got = stats
+	})
+	doc.Unlock()
+	// edited before the end of the count in background
+	for i := 0; i < 5; i++ {
+		doc.Lock()
+		doc.Insert(0, "x ")
+		doc.Delete(doc.GetTotalLength()/2, doc.GetTotalLength()/2+30)
+		doc.appendText("log line\r\n")
+		doc.Unlock()
+	}
+	doc.Lock()
+	expected := doc.GetStatistics()
+	doc.Unlock()
+	for !got.Complete {
+		select {
+		case f := <-queue:
+			f()
+		case <-time.After(5 * time.Second):
+			t.Fatal("count not finished")
+		}
+	}
+	if got != expected {
+		t.Fatalf("%v, expected\n%v", got, expected)
+	}
+
+	// then counted incrementally
+	doc.Lock()
+	doc.Insert(7, "\n\n")
+	updater.Close()
+	doc.Unlock()
+	if expected := doc.GetStatistics(); got != expected {
+		t.Fatalf("%v, expected\n%v", got, expected)
+	}
+}
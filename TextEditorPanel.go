@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	"image/color"
 )
@@ -14,12 +16,19 @@ type TextEditorPanel struct {
 	cursorGlobalIndex           uint64
 	maxCharactersPerLine        int
 	lines                       []TextLine
+	columns                     *ColumnModel
+	// following is true when the document follows its file, pinnedToEnd keeps the end visible until the user scrolls up
+	following   bool
+	pinnedToEnd bool
+	// paged is the paged document whose window is edited, the window moves when scrolling past its ends
+	paged *PagedDocument
 }
 
 func NewTextEditorPanel() *TextEditorPanel {
 	editor := &TextEditorPanel{
 		maxCharactersPerLine: 40,
 		lines:                []TextLine{},
+		columns:              NewColumnModel(DEFAULT_TAB_WIDTH),
 	}
 	editor.ExtendBaseWidget(editor)
 	return editor
@@ -38,6 +47,251 @@ func (editor *TextEditorPanel) GetDocument() *Document {
 	return editor.doc
 }
 
+// GetCursorIndex returns the line and the index in the line of the cursor, the caller holds the lock of the document
+func (editor *TextEditorPanel) GetCursorIndex() *Index {
+	return editor.doc.GetIndex(int64(editor.cursorGlobalIndex))
+}
+
+// GetColumnModel returns the model computing the visual columns of the characters of the lines
+func (editor *TextEditorPanel) GetColumnModel() *ColumnModel {
+	return editor.columns
+}
+
+// SetTabWidth sets the number of columns between two tab stops
+func (editor *TextEditorPanel) SetTabWidth(tabWidth int) {
+	editor.columns.SetTabWidth(tabWidth)
+	editor.Refresh()
+}
+
+// GetCursorColumn returns the visual column of the cursor in its line, the caller holds the lock of the document
+func (editor *TextEditorPanel) GetCursorColumn() int {
+	index := editor.GetCursorIndex()
+	line := editor.doc.GetLine(index.GetLineIndex())
+	// the cursor can be inside a CRLF
+	return editor.columns.GetColumn(line, minInt64(index.GetCharIndexInLine(), line.Length()))
+}
+
+// SetDocument replaces the edited document and moves to its beginning
+func (editor *TextEditorPanel) SetDocument(doc *Document) {
+	editor.paged = nil
+	editor.listenTo(doc)
+	editor.firstVisibleLineGlobalIndex = 0
+	editor.cursorGlobalIndex = 0
+	editor.following = false
+	editor.pinnedToEnd = false
+	editor.Refresh()
+}
+
+// SetPagedDocument edits the window document of a paged document, and moves to the beginning of the window
+func (editor *TextEditorPanel) SetPagedDocument(paged *PagedDocument) {
+	editor.SetDocument(paged.GetDocument())
+	editor.paged = paged
+}
+
+// GetPagedDocument returns the paged document set by SetPagedDocument, nil if the edited document is not paged
+func (editor *TextEditorPanel) GetPagedDocument() *PagedDocument {
+	return editor.paged
+}
+
+// ReplaceDocument replaces the edited document by another version of it, like the complete document after a preview,
+// keeping the visible lines and the cursor
+func (editor *TextEditorPanel) ReplaceDocument(doc *Document) {
+	editor.listenTo(doc)
+	doc.Lock()
+	editor.clampIndexes()
+	doc.Unlock()
+	editor.Refresh()
+}
+
+// listenTo replaces the edited document, the editor follows the changes of the new one
+func (editor *TextEditorPanel) listenTo(doc *Document) {
+	if editor.doc != nil {
+		editor.doc.Lock()
+		editor.doc.RemoveDocumentListener(editor)
+		editor.doc.Unlock()
+	}
+	editor.doc = doc
+	if doc != nil {
+		doc.Lock()
+		doc.AddDocumentListener(editor)
+		doc.Unlock()
+	}
+}
+
+// clampIndexes keeps the cursor and the first visible line inside the document
+func (editor *TextEditorPanel) clampIndexes() {
+	length := uint64(editor.doc.GetTotalLength())
+	if editor.cursorGlobalIndex > length {
+		editor.cursorGlobalIndex = length
+	}
+	if editor.firstVisibleLineGlobalIndex > length {
+		editor.firstVisibleLineGlobalIndex = length
+	}
+	// the first visible index must stay at the start of a line
+	line := editor.doc.GetIndex(int64(editor.firstVisibleLineGlobalIndex)).GetLineIndex()
+	editor.firstVisibleLineGlobalIndex = uint64(editor.doc.GetGlobalIndexOfLine(line))
+}
+
+// DocumentChanged moves the cursor and the visible lines with the text they are on
+func (editor *TextEditorPanel) DocumentChanged(event DocumentEvent) {
+	switch event.Type {
+	case EVENT_INSERTED:
+		length := uint64(event.End - event.Start)
+		if editor.cursorGlobalIndex >= uint64(event.Start) {
+			editor.cursorGlobalIndex += length
+		}
+		if editor.firstVisibleLineGlobalIndex > uint64(event.Start) {
+			editor.firstVisibleLineGlobalIndex += length
+		}
+	case EVENT_REMOVED:
+		editor.cursorGlobalIndex = shiftRemoved(editor.cursorGlobalIndex, event)
+		editor.firstVisibleLineGlobalIndex = shiftRemoved(editor.firstVisibleLineGlobalIndex, event)
+	case EVENT_RELOADED:
+	default:
+		return
+	}
+	editor.clampIndexes()
+	// when pinned to the end, DocumentAppended scrolls and refreshes
+	if !editor.pinnedToEnd {
+		editor.Refresh()
+	}
+}
+
+// shiftRemoved returns the index after the removal of the text of the event
+func shiftRemoved(index uint64, event DocumentEvent) uint64 {
+	if index >= uint64(event.End) {
+		return index - uint64(event.End-event.Start)
+	}
+	if index > uint64(event.Start) {
+		return uint64(event.Start)
+	}
+	return index
+}
+
+// SetFollowing tells if text is appended to the document by a Follower.
+// The view is then pinned to the end of the document, until the user scrolls up, and pinned again when scrolling back to the end.
+func (editor *TextEditorPanel) SetFollowing(following bool) {
+	editor.following = following
+	editor.pinnedToEnd = following
+	if following {
+		editor.ScrollToEnd()
+	}
+}
+
+// IsPinnedToEnd returns true if the end of the document stays visible when text is appended
+func (editor *TextEditorPanel) IsPinnedToEnd() bool {
+	return editor.pinnedToEnd
+}
+
+// DocumentAppended must be called after text is appended to the document, or after it is reloaded if truncated
+func (editor *TextEditorPanel) DocumentAppended(truncated bool) {
+	if truncated {
+		editor.firstVisibleLineGlobalIndex = 0
+		editor.cursorGlobalIndex = 0
+	}
+	if editor.pinnedToEnd {
+		editor.ScrollToEnd()
+	} else {
+		editor.Refresh()
+	}
+}
+
+// ScrollToEnd shows the last lines of the document
+func (editor *TextEditorPanel) ScrollToEnd() {
+	if editor.doc == nil {
+		return
+	}
+	editor.doc.Lock()
+	defer editor.doc.Unlock()
+	lineCount := editor.doc.GetLineCount()
+	first := lineCount - editor.getVisibleLineCount()
+	if first < 0 {
+		first = 0
+	}
+	editor.firstVisibleLineGlobalIndex = uint64(editor.doc.GetGlobalIndexOfLine(first))
+	editor.Refresh()
+}
+
+// Scrolled moves the visible lines, scrolling up unpins the view from the end of the document
+func (editor *TextEditorPanel) Scrolled(event *fyne.ScrollEvent) {
+	if editor.doc == nil {
+		return
+	}
+	delta := int(-event.Scrolled.DY / editor.getLineHeight())
+	if delta == 0 {
+		return
+	}
+	editor.doc.Lock()
+	defer editor.doc.Unlock()
+	lineCount := editor.doc.GetLineCount()
+	last := lineCount - editor.getVisibleLineCount()
+	if last < 0 {
+		last = 0
+	}
+	first := editor.doc.GetIndex(int64(editor.firstVisibleLineGlobalIndex)).GetLineIndex() + delta
+	if editor.paged != nil && ((first < 0 && editor.paged.HasPreviousPage()) || (first > last && editor.paged.HasNextPage())) {
+		editor.scrollWindow(delta)
+		return
+	}
+	if first < 0 {
+		first = 0
+	}
+	if first >= last {
+		// back at the end
+		first = last
+		editor.pinnedToEnd = editor.following
+	} else if delta < 0 {
+		editor.pinnedToEnd = false
+	}
+	editor.firstVisibleLineGlobalIndex = uint64(editor.doc.GetGlobalIndexOfLine(first))
+	editor.Refresh()
+}
+
+// scrollWindow moves the window of the paged document to scroll past its ends, the document is locked
+func (editor *TextEditorPanel) scrollWindow(delta int) {
+	windowStart := editor.paged.GetWindowStart()
+	firstVisible := windowStart + int64(editor.firstVisibleLineGlobalIndex)
+	cursor := windowStart + int64(editor.cursorGlobalIndex)
+	var err error
+	if delta < 0 {
+		err = editor.paged.LoadPreviousPage()
+	} else {
+		err = editor.paged.LoadNextPage()
+	}
+	if err != nil {
+		fmt.Printf("Cannot load the page: %v\n", err)
+		return
+	}
+	// the same text at other indexes of the window
+	windowStart = editor.paged.GetWindowStart()
+	editor.firstVisibleLineGlobalIndex = uint64(maxInt64(0, firstVisible-windowStart))
+	editor.cursorGlobalIndex = uint64(maxInt64(0, cursor-windowStart))
+	editor.clampIndexes()
+	last := editor.doc.GetLineCount() - editor.getVisibleLineCount()
+	first := editor.doc.GetIndex(int64(editor.firstVisibleLineGlobalIndex)).GetLineIndex() + delta
+	if first > last {
+		first = last
+	}
+	if first < 0 {
+		first = 0
+	}
+	editor.firstVisibleLineGlobalIndex = uint64(editor.doc.GetGlobalIndexOfLine(first))
+	editor.Refresh()
+}
+
+func (editor *TextEditorPanel) getLineHeight() float32 {
+	return fyne.MeasureText("M", theme.TextSize(), fyne.TextStyle{Monospace: true}).Height
+}
+
+// getVisibleLineCount returns the number of lines fitting in the panel
+func (editor *TextEditorPanel) getVisibleLineCount() int {
+	count := int(editor.Size().Height / editor.getLineHeight())
+	if count < 1 {
+		return 1
+	}
+	return count
+}
+
 type TextEditorRenderer struct {
 	editor     *TextEditorPanel
 	background *canvas.Rectangle
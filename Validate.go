@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ViolationType is the kind of a Violation
+type ViolationType int
+
+const (
+	// VIOLATION_LENGTH: the length of a line doesn't match its parts and its end of line
+	VIOLATION_LENGTH ViolationType = iota
+	// VIOLATION_LINE_INDEX: the index of a line is not its position in the document
+	VIOLATION_LINE_INDEX
+	// VIOLATION_EMPTY_PART: a line has an empty part, only an empty line can have a single empty part
+	VIOLATION_EMPTY_PART
+	// VIOLATION_CUT_CODE_POINT: a code point is cut between two parts of a line
+	VIOLATION_CUT_CODE_POINT
+	// VIOLATION_END_OF_LINE: a line other than the last one has no end of line, or the last one has one
+	VIOLATION_END_OF_LINE
+	// VIOLATION_CONTENT: the text of a line is not the text of the piece table at its offset
+	VIOLATION_CONTENT
+	// VIOLATION_OFFSETS: the line offsets don't match the lines
+	VIOLATION_OFFSETS
+	// VIOLATION_TOTAL_LENGTH: the cached total length is stale, or the lines don't cover the text
+	VIOLATION_TOTAL_LENGTH
+)
+
+// String returns the name of the violation type.
+func (t ViolationType) String() string {
+	switch t {
+	case VIOLATION_LENGTH:
+		return "length"
+	case VIOLATION_LINE_INDEX:
+		return "line index"
+	case VIOLATION_EMPTY_PART:
+		return "empty part"
+	case VIOLATION_CUT_CODE_POINT:
+		return "cut code point"
+	case VIOLATION_END_OF_LINE:
+		return "end of line"
+	case VIOLATION_CONTENT:
+		return "content"
+	case VIOLATION_OFFSETS:
+		return "offsets"
+	case VIOLATION_TOTAL_LENGTH:
+		return "total length"
+	}
+	return fmt.Sprintf("ViolationType(%d)", int(t))
+}
+
+// Violation is an inconsistency of a document found by Validate
+type Violation struct {
+	Type ViolationType
+	// Line is the index of the line, -1 for the whole document
+	Line    int
+	Message string
+}
+
+// String returns a string representation of the violation.
+func (v Violation) String() string {
+	if v.Line < 0 {
+		return fmt.Sprintf("%s: %s", v.Type, v.Message)
+	}
+	return fmt.Sprintf("%s at line %d: %s", v.Type, v.Line, v.Message)
+}
+
+// Validate checks the invariants of the document and of all its lines, and returns the violations found.
+// It is meant for the tests and for debugging, see VALIDATE_EDITS.
+func (doc *Document) Validate() []Violation {
+	violations := doc.validateLines(0, doc.GetLineCount())
+	length := doc.table.Length()
+	if doc.totalLength >= 0 && doc.totalLength != length {
+		violations = append(violations, Violation{VIOLATION_TOTAL_LENGTH, -1, fmt.Sprintf("total length %d, the text has %d bytes", doc.totalLength, length)})
+	}
+	if doc.offsets != nil && doc.offsets.GetLineCount() != doc.GetLineCount() {
+		violations = append(violations, Violation{VIOLATION_OFFSETS, -1, fmt.Sprintf("%d line offsets for %d lines", doc.offsets.GetLineCount(), doc.GetLineCount())})
+	}
+	end := int64(0)
+	if count := doc.GetLineCount(); count > 0 {
+		last := doc.GetLine(count - 1)
+		end = doc.GetGlobalIndexOfLine(count-1) + last.GetLengthWithEOL()
+	}
+	if end != length {
+		violations = append(violations, Violation{VIOLATION_TOTAL_LENGTH, -1, fmt.Sprintf("the lines end at %d, the text has %d bytes", end, length)})
+	}
+	return violations
+}
+
+// validateLines checks the lines first (inclusive) to last (exclusive)
+func (doc *Document) validateLines(first int, last int) []Violation {
+	var violations []Violation
+	add := func(t ViolationType, line int, format string, args ...any) {
+		violations = append(violations, Violation{t, line, fmt.Sprintf(format, args...)})
+	}
+	count := doc.GetLineCount()
+	offset := doc.GetGlobalIndexOfLine(first)
+	for i := first; i < last; i++ {
+		line := doc.GetLine(i)
+		if line.lineIndex != i {
+			add(VIOLATION_LINE_INDEX, i, "the line has the index %d", line.lineIndex)
+		}
+		length := int64(0)
+		for j, part := range line.parts {
+			length += int64(len(part))
+			if part == "" && len(line.parts) > 1 {
+				add(VIOLATION_EMPTY_PART, i, "the part %d is empty", j)
+			}
+		}
+		if line.length != length {
+			add(VIOLATION_LENGTH, i, "length %d, the parts have %d bytes", line.length, length)
+		}
+		if line.lengthWithEOL != length+int64(len(line.getEOL())) {
+			add(VIOLATION_LENGTH, i, "length with end of line %d, expected %d", line.lengthWithEOL, length+int64(len(line.getEOL())))
+		}
+		if err := line.checkPartBoundaries(); err != nil {
+			add(VIOLATION_CUT_CODE_POINT, i, "%v", err)
+		}
+		if line.endsWithNewLine != (i < count-1) {
+			add(VIOLATION_END_OF_LINE, i, "ends with a new line: %t, line count is %d", line.endsWithNewLine, count)
+		}
+		if doc.offsets != nil && i < doc.offsets.GetLineCount() && doc.offsets.GetLength(i) != line.lengthWithEOL {
+			add(VIOLATION_OFFSETS, i, "the offsets give the length %d, the line has %d", doc.offsets.GetLength(i), line.lengthWithEOL)
+		}
+		end := offset + length + int64(len(line.getEOL()))
+		if end > doc.table.Length() {
+			add(VIOLATION_CONTENT, i, "the line ends at %d, after the end of the text (%d)", end, doc.table.Length())
+			break
+		}
+		if text := string(doc.table.Bytes(offset, end)); text != strings.Join(line.parts, "")+line.getEOL() {
+			add(VIOLATION_CONTENT, i, "the line is %q, the text at %d is %q", truncateForMessage(strings.Join(line.parts, "")), offset, truncateForMessage(text))
+		}
+		offset = end
+	}
+	return violations
+}
+
+// checkEdit panics if the lines changed by an edit are not valid, when VALIDATE_EDITS is true
+func (doc *Document) checkEdit(change lineChange) {
+	first := maxInt(0, change.first-1)
+	last := minInt(doc.GetLineCount(), change.first+change.added+1)
+	violations := doc.validateLines(first, last)
+	if doc.totalLength >= 0 && doc.totalLength != doc.table.Length() {
+		violations = append(violations, Violation{VIOLATION_TOTAL_LENGTH, -1, fmt.Sprintf("total length %d, the text has %d bytes", doc.totalLength, doc.table.Length())})
+	}
+	if len(violations) > 0 {
+		panic(fmt.Sprintf("invalid document after an edit: %v", violations))
+	}
+}
+
+// truncateForMessage shortens a text shown in a violation
+func truncateForMessage(text string) string {
+	if len(text) > 40 {
+		return text[:40] + "..."
+	}
+	return text
+}
@@ -0,0 +1,7 @@
+//go:build debug
+
+package main
+
+// VALIDATE_EDITS is true in the debug builds (-tags debug): the lines around each edit are validated,
+// and an invalid document panics
+const VALIDATE_EDITS = true
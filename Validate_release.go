@@ -0,0 +1,6 @@
+//go:build !debug
+
+package main
+
+// VALIDATE_EDITS is true in the debug builds (-tags debug), see Validate_debug.go
+const VALIDATE_EDITS = false
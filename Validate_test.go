@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// validate fails the test if the document has violations
+func validate(t *testing.T, doc *Document, step string) {
+	t.Helper()
+	if violations := doc.Validate(); len(violations) > 0 {
+		t.Fatalf("%s: %v", step, violations)
+	}
+}
+
+func TestValidateEditsUndoRedo(t *testing.T) {
+	doc := NewDocument()
+	validate(t, doc, "empty")
+	doc.LoadFromString("hello\r\nwörld\nà́b\r\n\nend", 3)
+	validate(t, doc, "load")
+
+	r := rand.New(rand.NewSource(1))
+	texts := []string{"a", "\n", "\r", "\r\n", "é", "xyz\nq", "👍🏽"}
+	// versions are the texts of the document after each edit, for the undo and the redo
+	versions := []string{doc.GetText(0, doc.GetTotalLength())}
+	for i := 0; i < 1000; i++ {
+		n := doc.GetTotalLength()
+		if r.Intn(3) > 0 || n == 0 {
+			pos := r.Int63n(n + 1)
+			for pos > 0 && pos < n && (doc.GetText(pos, pos+1)[0]&0xC0) == 0x80 {
+				// not inside a code point
+				pos--
+			}
+			doc.Insert(pos, texts[r.Intn(len(texts))])
+		} else {
+			pos := r.Int63n(n)
+			doc.Delete(pos, minInt64(n, pos+1+r.Int63n(5)))
+		}
+		validate(t, doc, "edit")
+		// one undo step per edit
+		doc.GetHistory().Close()
+		versions = append(versions, doc.GetText(0, doc.GetTotalLength()))
+	}
+
+	undone := 0
+	for doc.Undo() {
+		undone++
+		validate(t, doc, "undo")
+		if doc.GetText(0, doc.GetTotalLength()) != versions[len(versions)-1-undone] {
+			t.Fatalf("undo %d: wrong text", undone)
+		}
+	}
+	if undone == 0 {
+		t.Fatal("nothing undone")
+	}
+	for redone := 1; doc.Redo(); redone++ {
+		validate(t, doc, "redo")
+		if doc.GetText(0, doc.GetTotalLength()) != versions[len(versions)-1-undone+redone] {
+			t.Fatalf("redo %d: wrong text", redone)
+		}
+	}
+	if doc.GetText(0, doc.GetTotalLength()) != versions[len(versions)-1] {
+		t.Fatal("the last version is not restored by the redo")
+	}
+}
+
+func TestValidateViolations(t *testing.T) {
+	doc := NewDocument()
+	doc.LoadFromString("abc\ndef\nghi", 0)
+	doc.GetLine(1).parts = []string{"d", "", "ef"}
+	doc.GetLine(2).lineIndex = 5
+	doc.GetLine(0).length = 2
+	doc.totalLength = 3
+	var report strings.Builder
+	for _, violation := range doc.Validate() {
+		report.WriteString(violation.String() + "\n")
+	}
+	for _, expected := range []string{"empty part at line 1", "line index at line 2", "length at line 0", "total length: total length 3"} {
+		if !strings.Contains(report.String(), expected) {
+			t.Errorf("missing %q in:\n%s", expected, report.String())
+		}
+	}
+
+	doc = NewDocument()
+	doc.LoadFromString("abc\nxyz", 0)
+	doc.GetLine(1).parts = []string{"\xc3", "\xa9"}
+	for _, violation := range doc.Validate() {
+		if violation.Type == VIOLATION_CUT_CODE_POINT {
+			return
+		}
+	}
+	t.Error("code point cut between two parts not reported")
+}